@@ -0,0 +1,267 @@
+package broker
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/koron/go-mqtt/mqtopic"
+	"github.com/koron/go-mqtt/packet"
+	"github.com/koron/go-mqtt/server"
+)
+
+// maxQoS is the maximum QoS which the broker can deliver to subscribers.
+const maxQoS = server.ExactlyOnce
+
+// defaultMaxQueuedMessages is used when Broker.MaxQueuedMessages is zero.
+const defaultMaxQueuedMessages = 1000
+
+// Broker is a MQTT broker which implements server.Adapter.  It routes
+// messages between clients, keeps retained messages, publishes will messages
+// and holds sessions of clients which connect without CleanSession.
+//
+// Messages are queued for each session and delivered by a goroutine of the
+// session, so a slow subscriber doesn't block publishers.
+//
+// Zero value is ready to use.
+type Broker struct {
+	// MaxQueuedMessages limits number of messages which are queued for each
+	// session, which is offline or slow to receive.  The oldest message is
+	// dropped when over.  Zero means 1000.
+	MaxQueuedMessages int
+
+	// QueueQoS0 enables to queue QoS 0 messages for offline sessions too.
+	QueueQoS0 bool
+
+	// DisconnectSlowConsumers disconnects a client when its queue is full,
+	// in addition to dropping the oldest message.
+	DisconnectSlowConsumers bool
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	retained map[string]*retained
+	seq      uint64
+}
+
+var _ server.Adapter = (*Broker)(nil)
+
+// retained is a retained message.
+type retained struct {
+	topic mqtopic.Topic
+	m     *server.Message
+}
+
+func (b *Broker) init() {
+	if b.sessions == nil {
+		b.sessions = make(map[string]*session)
+	}
+	if b.retained == nil {
+		b.retained = make(map[string]*retained)
+	}
+}
+
+func (b *Broker) maxQueuedMessages() int {
+	if b.MaxQueuedMessages <= 0 {
+		return defaultMaxQueuedMessages
+	}
+	return b.MaxQueuedMessages
+}
+
+// newClientID generates an unique client ID for a client which connects with
+// empty client ID.
+func (b *Broker) newClientID() string {
+	for {
+		b.seq++
+		id := fmt.Sprintf("go-mqtt-broker-%d", b.seq)
+		if _, ok := b.sessions[id]; !ok {
+			return id
+		}
+	}
+}
+
+// Connect is called when a new client try to connect MQTT broker.
+func (b *Broker) Connect(srv *server.Server, c server.Client, p *packet.Connect) (server.ClientAdapter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	id := p.ClientID
+	if id == "" {
+		// [MQTT-3.1.3-8]
+		if !p.CleanSession {
+			return nil, server.ErrIdentifierRejected
+		}
+		id = b.newClientID()
+	}
+	s, present := b.sessions[id]
+	if present && s.ca != nil {
+		// take over the session from the current connection. [MQTT-3.1.4-2]
+		s.ca.c.Close()
+		s.ca = nil
+	}
+	if !present || s.clean || p.CleanSession {
+		s = newSession(id)
+		b.sessions[id] = s
+		present = false
+	}
+	s.clean = p.CleanSession
+	ca := &clientAdapter{
+		b:       b,
+		s:       s,
+		c:       c,
+		present: present,
+	}
+	if p.WillFlag {
+		ca.will = &server.Message{
+			QoS:    toQoS(p.WillQoS),
+			Retain: p.WillRetain,
+			Topic:  p.WillTopic,
+			Body:   []byte(p.WillMessage),
+		}
+	}
+	s.ca = ca
+	if len(s.queue) > 0 {
+		// deliver queued messages after CONNACK.
+		s.flushing = true
+		go b.flush(s, ca)
+	}
+	return ca, nil
+}
+
+// Disconnect is called when a client disconnected.
+func (b *Broker) Disconnect(srv *server.Server, ca server.ClientAdapter, err error) {
+	ca2, ok := ca.(*clientAdapter)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	will := ca2.will
+	ca2.will = nil
+	s := ca2.s
+	// the session may be taken over by a new connection already.
+	if s.ca == ca2 {
+		s.ca = nil
+		s.flushing = false
+		if s.clean && b.sessions[s.id] == s {
+			delete(b.sessions, s.id)
+		}
+	}
+	b.mu.Unlock()
+	if will != nil {
+		// the client didn't send DISCONNECT. [MQTT-3.1.2-8]
+		b.publish(will)
+	}
+}
+
+// publish routes a message to subscribers, and keeps it as retained message
+// if required.
+func (b *Broker) publish(m *server.Message) error {
+	topic, err := mqtopic.Parse(m.Topic)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.init()
+	if m.Retain {
+		b.retain(topic, m)
+	}
+	for _, s := range b.sessions {
+		q, ok := s.match(topic)
+		if !ok {
+			continue
+		}
+		// Retain flag is cleared for established subscriptions.
+		// [MQTT-3.3.1-9]
+		dm := &server.Message{
+			QoS:   min(m.QoS, q),
+			Topic: m.Topic,
+			Body:  m.Body,
+		}
+		if s.ca == nil {
+			if dm.QoS != server.AtMostOnce || b.QueueQoS0 {
+				s.enqueue(dm, b.maxQueuedMessages())
+			}
+			continue
+		}
+		if len(s.queue) >= b.maxQueuedMessages() && b.DisconnectSlowConsumers {
+			// the client doesn't keep up with messages.
+			s.ca.c.Close()
+		}
+		s.enqueue(dm, b.maxQueuedMessages())
+		if !s.flushing {
+			s.flushing = true
+			go b.flush(s, s.ca)
+		}
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// retain updates a retained message for the topic.  A message with empty
+// body removes it. [MQTT-3.3.1-10]
+func (b *Broker) retain(topic mqtopic.Topic, m *server.Message) {
+	if len(m.Body) == 0 {
+		delete(b.retained, m.Topic)
+		return
+	}
+	b.retained[m.Topic] = &retained{
+		topic: topic,
+		m: &server.Message{
+			QoS:    m.QoS,
+			Retain: true,
+			Topic:  m.Topic,
+			Body:   m.Body,
+		},
+	}
+}
+
+// matchRetained returns retained messages which match with the filter.
+func (b *Broker) matchRetained(f mqtopic.Filter, qos server.QoS) []*server.Message {
+	var msgs []*server.Message
+	for _, r := range b.retained {
+		if !f.Match(r.topic) {
+			continue
+		}
+		msgs = append(msgs, &server.Message{
+			QoS:    min(r.m.QoS, qos),
+			Retain: true,
+			Topic:  r.m.Topic,
+			Body:   r.m.Body,
+		})
+	}
+	return msgs
+}
+
+// flush delivers queued messages of a session to the client, until the queue
+// is empty or the client is disconnected.
+func (b *Broker) flush(s *session, ca *clientAdapter) {
+	for {
+		b.mu.Lock()
+		if s.ca != ca || len(s.queue) == 0 {
+			if s.ca == ca {
+				s.flushing = false
+			}
+			b.mu.Unlock()
+			return
+		}
+		m := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		b.mu.Unlock()
+		if err := ca.deliver(m); err != nil {
+			b.mu.Lock()
+			s.queue = append([]*server.Message{m}, s.queue...)
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+func toQoS(q packet.QoS) server.QoS {
+	switch q {
+	case packet.QAtLeastOnce:
+		return server.AtLeastOnce
+	case packet.QExactlyOnce:
+		return server.ExactlyOnce
+	default:
+		return server.AtMostOnce
+	}
+}
@@ -0,0 +1,316 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/client"
+	"github.com/koron/go-mqtt/packet"
+	"github.com/koron/go-mqtt/server"
+)
+
+func startServer(t *testing.T, b *Broker) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	srv := &server.Server{Adapter: b}
+	done := make(chan struct{})
+	go func() {
+		srv.Serve(l)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+		<-done
+	})
+	return "tcp://" + l.Addr().String()
+}
+
+type testClient struct {
+	client.Client
+	ch <-chan *client.Message
+}
+
+func connect(t *testing.T, addr, id string, clean bool) *testClient {
+	t.Helper()
+	c, err := client.Connect(client.Param{
+		Addr: addr,
+		ID:   id,
+		Options: &client.Options{
+			Version:      4,
+			CleanSession: clean,
+			KeepAlive:    60,
+		},
+	})
+	if err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		c.Disconnect(true)
+	})
+	return &testClient{Client: c, ch: c.Messages(ctx)}
+}
+
+func (tc *testClient) subscribe(t *testing.T, filters ...string) {
+	t.Helper()
+	var topics []client.Topic
+	for _, f := range filters {
+		topics = append(topics, client.Topic{Filter: f, QoS: client.AtMostOnce})
+	}
+	if err := tc.Subscribe(topics); err != nil {
+		t.Fatalf("subscribe failed: %s", err)
+	}
+}
+
+func (tc *testClient) publish(t *testing.T, retain bool, topic, body string) {
+	t.Helper()
+	if err := tc.Publish(client.AtMostOnce, retain, topic, []byte(body)); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+}
+
+func (tc *testClient) recv(t *testing.T) *client.Message {
+	t.Helper()
+	select {
+	case m := <-tc.ch:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out to receive a message")
+		return nil
+	}
+}
+
+func (tc *testClient) recvNone(t *testing.T) {
+	t.Helper()
+	select {
+	case m := <-tc.ch:
+		t.Fatalf("unexpected message: topic=%s body=%s", m.Topic, m.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (tc *testClient) ping(t *testing.T) {
+	t.Helper()
+	if err := tc.Ping(); err != nil {
+		t.Fatalf("ping failed: %s", err)
+	}
+}
+
+func assertMessage(t *testing.T, m *client.Message, topic, body string, retain bool) {
+	t.Helper()
+	if m.Topic != topic || string(m.Body) != body || m.Retain != retain {
+		t.Errorf("unexpected message: topic=%s body=%s retain=%t", m.Topic, m.Body, m.Retain)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	addr := startServer(t, &Broker{})
+	c0 := connect(t, addr, "c0", true)
+	c0.subscribe(t, "a/+", "a/#", "$SYS/#")
+	c1 := connect(t, addr, "c1", true)
+	c1.subscribe(t, "b")
+
+	c1.publish(t, false, "a/1", "hello")
+	c1.publish(t, false, "b", "self")
+	c1.publish(t, false, "c", "nobody")
+	// overlapping subscriptions deliver a message only once.
+	assertMessage(t, c0.recv(t), "a/1", "hello", false)
+	assertMessage(t, c1.recv(t), "b", "self", false)
+	c0.recvNone(t)
+
+	if err := c0.Unsubscribe([]string{"a/+", "a/#"}); err != nil {
+		t.Fatalf("unsubscribe failed: %s", err)
+	}
+	c1.publish(t, false, "a/2", "world")
+	c1.ping(t)
+	c0.recvNone(t)
+}
+
+func TestRetained(t *testing.T) {
+	addr := startServer(t, &Broker{})
+	c0 := connect(t, addr, "c0", true)
+	c0.publish(t, true, "r/1", "one")
+	c0.publish(t, true, "r/2", "two")
+	c0.publish(t, true, "r/2", "")
+	c0.ping(t)
+
+	c1 := connect(t, addr, "c1", true)
+	c1.subscribe(t, "r/#")
+	assertMessage(t, c1.recv(t), "r/1", "one", true)
+	c1.recvNone(t)
+
+	// retain flag is cleared for established subscriptions.
+	c0.publish(t, true, "r/3", "three")
+	assertMessage(t, c1.recv(t), "r/3", "three", false)
+}
+
+func TestWill(t *testing.T) {
+	addr := startServer(t, &Broker{})
+	c0 := connect(t, addr, "c0", true)
+	c0.subscribe(t, "will/#")
+
+	dial := func(id string) net.Conn {
+		conn, err := net.Dial("tcp", addr[len("tcp://"):])
+		if err != nil {
+			t.Fatalf("dial failed: %s", err)
+		}
+		b, _ := (&packet.Connect{
+			ClientID:     id,
+			Version:      4,
+			CleanSession: true,
+			WillFlag:     true,
+			WillTopic:    "will/" + id,
+			WillMessage:  "bye",
+		}).Encode()
+		conn.Write(b)
+		if _, err := packet.SplitDecode(bufio.NewReader(conn)); err != nil {
+			t.Fatalf("CONNACK failed: %s", err)
+		}
+		return conn
+	}
+
+	// normal disconnection discards the will message.
+	conn := dial("c1")
+	b, _ := (&packet.Disconnect{}).Encode()
+	conn.Write(b)
+	conn.Close()
+	c0.recvNone(t)
+
+	// abnormal disconnection publishes the will message.
+	conn = dial("c2")
+	conn.Close()
+	assertMessage(t, c0.recv(t), "will/c2", "bye", false)
+}
+
+func TestPersistentSession(t *testing.T) {
+	addr := startServer(t, &Broker{QueueQoS0: true})
+	c0 := connect(t, addr, "c0", false)
+	c0.subscribe(t, "p")
+	c0.Disconnect(true)
+
+	c1 := connect(t, addr, "c1", true)
+	c1.publish(t, false, "p", "while offline")
+	c1.ping(t)
+
+	c0 = connect(t, addr, "c0", false)
+	assertMessage(t, c0.recv(t), "p", "while offline", false)
+	c1.publish(t, false, "p", "online")
+	assertMessage(t, c0.recv(t), "p", "online", false)
+
+	// clean session discards the previous session.
+	c0.Disconnect(true)
+	c0 = connect(t, addr, "c0", true)
+	c1.publish(t, false, "p", "discarded")
+	c1.ping(t)
+	c0.recvNone(t)
+}
+
+func TestTakeOver(t *testing.T) {
+	addr := startServer(t, &Broker{})
+	c0 := connect(t, addr, "c0", false)
+	c0.subscribe(t, "t")
+	c0b := connect(t, addr, "c0", false)
+	c1 := connect(t, addr, "c1", true)
+	c1.publish(t, false, "t", "to new one")
+	assertMessage(t, c0b.recv(t), "t", "to new one", false)
+}
+
+func TestQoS1(t *testing.T) {
+	addr := startServer(t, &Broker{})
+	c0 := connect(t, addr, "c0", true)
+	if err := c0.Subscribe([]client.Topic{{Filter: "q/#", QoS: client.AtLeastOnce}}); err != nil {
+		t.Fatalf("subscribe failed: %s", err)
+	}
+	c1 := connect(t, addr, "c1", true)
+	if err := c1.Publish(client.AtLeastOnce, false, "q/1", []byte("one")); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+	c1.publish(t, false, "q/0", "zero")
+	if m := c0.recv(t); m.Topic != "q/1" || m.QoS != client.AtLeastOnce {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	if m := c0.recv(t); m.Topic != "q/0" || m.QoS != client.AtMostOnce {
+		t.Errorf("unexpected message: %+v", m)
+	}
+}
+
+// dialSlow connects a client which subscribes the filter, and never reads
+// packets after SUBACK.
+func dialSlow(t *testing.T, addr, id, filter string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr[len("tcp://"):])
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	r := bufio.NewReader(conn)
+	b, _ := (&packet.Connect{ClientID: id, Version: 4, CleanSession: true}).Encode()
+	conn.Write(b)
+	if _, err := packet.SplitDecode(r); err != nil {
+		t.Fatalf("CONNACK failed: %s", err)
+	}
+	b, _ = (&packet.Subscribe{
+		PacketID: 1,
+		Topics:   []packet.Topic{{Filter: filter, RequestedQoS: packet.QAtMostOnce}},
+	}).Encode()
+	conn.Write(b)
+	if _, err := packet.SplitDecode(r); err != nil {
+		t.Fatalf("SUBACK failed: %s", err)
+	}
+	return conn
+}
+
+func publishMany(t *testing.T, tc *testClient, topic string) {
+	t.Helper()
+	body := make([]byte, 64*1024)
+	for i := 0; i < 200; i++ {
+		if err := tc.Publish(client.AtMostOnce, false, topic, body); err != nil {
+			t.Fatalf("publish failed: %s", err)
+		}
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	addr := startServer(t, &Broker{MaxQueuedMessages: 10})
+	dialSlow(t, addr, "slow", "s")
+	c0 := connect(t, addr, "c0", true)
+	c0.subscribe(t, "s")
+	c1 := connect(t, addr, "c1", true)
+
+	// the slow consumer doesn't block the publisher and other subscribers.
+	publishMany(t, c1, "s")
+	c1.publish(t, false, "s", "last")
+	c1.ping(t)
+	for {
+		if m := c0.recv(t); string(m.Body) == "last" {
+			break
+		}
+	}
+}
+
+func TestSlowConsumer_Disconnect(t *testing.T) {
+	addr := startServer(t, &Broker{MaxQueuedMessages: 10, DisconnectSlowConsumers: true})
+	conn := dialSlow(t, addr, "slow", "s")
+	c1 := connect(t, addr, "c1", true)
+	publishMany(t, c1, "s")
+	c1.ping(t)
+
+	// the slow consumer is disconnected.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 64*1024)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("slow consumer is not disconnected")
+			}
+			break
+		}
+	}
+}
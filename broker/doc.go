@@ -0,0 +1,4 @@
+/*
+Package broker provides MQTT broker, which implements server.Adapter.
+*/
+package broker
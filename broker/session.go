@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"github.com/koron/go-mqtt/mqtopic"
+	"github.com/koron/go-mqtt/server"
+)
+
+// session is a state of a client, which may live over connections.
+type session struct {
+	id    string
+	clean bool
+	subs  map[string]*subscription
+
+	// ca is the current connection, nil while offline.
+	ca *clientAdapter
+
+	// queue holds messages to be delivered after (re)connected.
+	queue    []*server.Message
+	flushing bool
+}
+
+type subscription struct {
+	filter mqtopic.Filter
+	qos    server.QoS
+}
+
+func newSession(id string) *session {
+	return &session{
+		id:   id,
+		subs: make(map[string]*subscription),
+	}
+}
+
+// match checks the topic matches with subscriptions, and returns the maximum
+// QoS of them. [MQTT-3.3.5-1]
+func (s *session) match(topic mqtopic.Topic) (server.QoS, bool) {
+	var (
+		qos     server.QoS
+		matched bool
+	)
+	for _, sub := range s.subs {
+		if !sub.filter.Match(topic) {
+			continue
+		}
+		if !matched || sub.qos > qos {
+			qos = sub.qos
+		}
+		matched = true
+	}
+	return qos, matched
+}
+
+// enqueue queues a message, and drops the oldest one when the queue is full.
+func (s *session) enqueue(m *server.Message, max int) {
+	if len(s.queue) >= max {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, m)
+}
+
+// clientAdapter is a connection of a client.
+type clientAdapter struct {
+	b       *Broker
+	s       *session
+	c       server.Client
+	present bool
+	will    *server.Message
+}
+
+var _ server.ClientAdapter = (*clientAdapter)(nil)
+
+func (ca *clientAdapter) ID() string {
+	return ca.s.id
+}
+
+func (ca *clientAdapter) IsSessionPresent() bool {
+	return ca.present
+}
+
+func (ca *clientAdapter) OnDisconnect() error {
+	// discard the will message. [MQTT-3.14.4-3]
+	ca.b.mu.Lock()
+	ca.will = nil
+	ca.b.mu.Unlock()
+	return nil
+}
+
+func (ca *clientAdapter) OnPing() (bool, error) {
+	return true, nil
+}
+
+// OnSubscribe adds subscriptions, and sends retained messages which match
+// with them.
+func (ca *clientAdapter) OnSubscribe(topics []server.Topic) ([]server.QoS, error) {
+	q := make([]server.QoS, len(topics))
+	var msgs []*server.Message
+	ca.b.mu.Lock()
+	ca.b.init()
+	for i, topic := range topics {
+		f, err := mqtopic.ParseFilter(topic.Filter)
+		if err != nil || topic.QoS > server.ExactlyOnce {
+			q[i] = server.Failure
+			continue
+		}
+		q[i] = min(topic.QoS, maxQoS)
+		ca.s.subs[topic.Filter] = &subscription{filter: f, qos: q[i]}
+		msgs = append(msgs, ca.b.matchRetained(f, q[i])...)
+	}
+	ca.b.mu.Unlock()
+	for _, m := range msgs {
+		ca.deliver(m)
+	}
+	return q, nil
+}
+
+func (ca *clientAdapter) OnUnsubscribe(filters []string) error {
+	ca.b.mu.Lock()
+	for _, f := range filters {
+		delete(ca.s.subs, f)
+	}
+	ca.b.mu.Unlock()
+	return nil
+}
+
+func (ca *clientAdapter) OnPublish(m *server.Message) error {
+	return ca.b.publish(m)
+}
+
+func (ca *clientAdapter) deliver(m *server.Message) error {
+	return ca.c.Publish(m.QoS, m.Retain, m.Topic, m.Body)
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+// testBroker is a scripted MQTT broker to test client's behaviors.
+type testBroker struct {
+	tb testing.TB
+	l  net.Listener
+}
+
+func newTestBroker(tb testing.TB) *testBroker {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("net.Listen failed: %s", err)
+	}
+	tb.Cleanup(func() { l.Close() })
+	return &testBroker{tb: tb, l: l}
+}
+
+func (b *testBroker) addr() string {
+	return "tcp://" + b.l.Addr().String()
+}
+
+// accept accepts a connection, receives CONNECT and replies CONNACK.
+func (b *testBroker) accept(ack *packet.ConnACK) *testConn {
+	b.tb.Helper()
+	conn, err := b.l.Accept()
+	if err != nil {
+		b.tb.Fatalf("accept failed: %s", err)
+	}
+	b.tb.Cleanup(func() { conn.Close() })
+	tc := &testConn{tb: b.tb, b: b, conn: conn, r: bufio.NewReader(conn)}
+	cp, ok := tc.recv().(*packet.Connect)
+	if !ok {
+		b.tb.Fatal("first packet is not CONNECT")
+	}
+	tc.connect = cp
+	if ack == nil {
+		ack = &packet.ConnACK{ReturnCode: packet.ConnectAccept}
+	}
+	tc.send(ack)
+	return tc
+}
+
+// testConn is a connection to a client from testBroker.
+type testConn struct {
+	tb   testing.TB
+	b    *testBroker
+	conn net.Conn
+	r    *bufio.Reader
+
+	// connect is the received CONNECT packet.
+	connect *packet.Connect
+}
+
+func (tc *testConn) recv() packet.Packet {
+	tc.tb.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := packet.SplitDecode(tc.r)
+	if err != nil {
+		tc.tb.Fatalf("failed to receive packet: %s", err)
+	}
+	return p
+}
+
+func (tc *testConn) send(p packet.Packet) {
+	tc.tb.Helper()
+	b, err := p.Encode()
+	if err != nil {
+		tc.tb.Fatalf("failed to encode packet: %s", err)
+	}
+	if _, err := tc.conn.Write(b); err != nil {
+		tc.tb.Fatalf("failed to send packet: %s", err)
+	}
+}
+
+// connectTestBroker connects a client to the broker.
+func connectTestBroker(t *testing.T, p Param) (*client, *testConn) {
+	t.Helper()
+	b := newTestBroker(t)
+	if p.ID == "" {
+		p.ID = "testclient"
+	}
+	if p.Options == nil {
+		p.Options = &Options{KeepAlive: 60, DisableAutoKeepAlive: true}
+	}
+	p.Addr = b.addr()
+	type result struct {
+		c   Client
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := Connect(p)
+		ch <- result{c, err}
+	}()
+	tc := b.accept(nil)
+	r := <-ch
+	if r.err != nil {
+		t.Fatalf("Connect failed: %s", r.err)
+	}
+	t.Cleanup(func() { r.c.Disconnect(true) })
+	return r.c.(*client), tc
+}
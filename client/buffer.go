@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// buffer is a ring buffer of received messages for Read.
+type buffer struct {
+	c      *sync.Cond
+	msgs   []*Message
+	head   int
+	n      int
+	policy OverflowPolicy
+	closed bool
+}
+
+func newBuffer(size int, policy OverflowPolicy) *buffer {
+	return &buffer{
+		c:      sync.NewCond(new(sync.Mutex)),
+		msgs:   make([]*Message, size),
+		policy: policy,
+	}
+}
+
+// put puts a message to the buffer.  When the buffer is full, a message is
+// dropped or this blocks, according to the policy.  The dropped message is
+// returned.
+func (b *buffer) put(m *Message) (dropped *Message) {
+	b.c.L.Lock()
+	defer b.c.L.Unlock()
+	for b.n == len(b.msgs) && b.policy == Block && !b.closed {
+		b.c.Wait()
+	}
+	if b.closed {
+		return m
+	}
+	if b.n == len(b.msgs) {
+		if b.policy == DropNewest {
+			return m
+		}
+		// DropOldest
+		dropped = b.msgs[b.head]
+		b.msgs[b.head] = nil
+		b.head = (b.head + 1) % len(b.msgs)
+		b.n--
+	}
+	b.msgs[(b.head+b.n)%len(b.msgs)] = m
+	b.n++
+	b.c.Broadcast()
+	return dropped
+}
+
+// get gets a message from the buffer.  It doesn't wait when ctx is nil.
+func (b *buffer) get(ctx context.Context) (*Message, error) {
+	if ctx != nil {
+		stop := context.AfterFunc(ctx, func() {
+			b.c.L.Lock()
+			b.c.Broadcast()
+			b.c.L.Unlock()
+		})
+		defer stop()
+	}
+	b.c.L.Lock()
+	defer b.c.L.Unlock()
+	for b.n == 0 {
+		if b.closed {
+			return nil, ErrTerminated
+		}
+		if ctx == nil {
+			return nil, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b.c.Wait()
+	}
+	if b.closed {
+		return nil, ErrTerminated
+	}
+	m := b.msgs[b.head]
+	b.msgs[b.head] = nil
+	b.head = (b.head + 1) % len(b.msgs)
+	b.n--
+	b.c.Broadcast()
+	return m, nil
+}
+
+// close discards all messages, and returns them.
+func (b *buffer) close() []*Message {
+	b.c.L.Lock()
+	defer b.c.L.Unlock()
+	discarded := make([]*Message, 0, b.n)
+	for ; b.n > 0; b.n-- {
+		discarded = append(discarded, b.msgs[b.head])
+		b.msgs[b.head] = nil
+		b.head = (b.head + 1) % len(b.msgs)
+	}
+	b.closed = true
+	b.c.Broadcast()
+	return discarded
+}
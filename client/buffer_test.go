@@ -0,0 +1,104 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestReadBuffer_Drop(t *testing.T) {
+	for _, tc := range []struct {
+		policy  OverflowPolicy
+		dropped string
+		read    []string
+	}{
+		{DropOldest, "a", []string{"b", "c"}},
+		{DropNewest, "c", []string{"a", "b"}},
+	} {
+		ch := make(chan *Message, 3)
+		c, conn := connectTestBroker(t, Param{
+			OnDrop: func(m *Message) { ch <- m },
+			Options: &Options{
+				KeepAlive:            60,
+				DisableAutoKeepAlive: true,
+				ReadBufferSize:       2,
+				ReadBufferPolicy:     tc.policy,
+			},
+		})
+		for _, topic := range []string{"a", "b", "c"} {
+			conn.send(&packet.Publish{TopicName: topic, Payload: []byte(topic)})
+		}
+		if m := <-ch; m.Topic != tc.dropped {
+			t.Errorf("policy=%d: unexpected dropped message: %+v", tc.policy, m)
+		}
+		for _, topic := range tc.read {
+			m, err := c.Read(true)
+			if err != nil {
+				t.Fatalf("policy=%d: Read failed: %s", tc.policy, err)
+			}
+			if m.Topic != topic {
+				t.Errorf("policy=%d: unexpected message: want=%s got=%s", tc.policy, topic, m.Topic)
+			}
+		}
+	}
+}
+
+func TestReadBuffer_Block(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			ReadBufferSize:       1,
+			ReadBufferPolicy:     Block,
+		},
+	})
+	for _, id := range []packet.ID{1, 2} {
+		tc.send(&packet.Publish{QoS: packet.QAtLeastOnce, PacketID: id, TopicName: "a", Payload: []byte("a")})
+	}
+	if ack := tc.recv().(*packet.PubACK); ack.PacketID != 1 {
+		t.Fatalf("unexpected PUBACK: %+v", ack)
+	}
+
+	// the second message is not acknowledged until Read.
+	tc.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := packet.SplitDecode(tc.r); err == nil {
+		t.Fatal("received unexpected packet while blocking")
+	}
+	if _, err := c.Read(true); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if ack := tc.recv().(*packet.PubACK); ack.PacketID != 2 {
+		t.Fatalf("unexpected PUBACK: %+v", ack)
+	}
+	if _, err := c.Read(true); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+}
+
+func TestReadBuffer_DropOnDisconnect(t *testing.T) {
+	var c *client
+	ch := make(chan error, 1)
+	c, tc := connectTestBroker(t, Param{
+		// OnDrop may call methods of the client.
+		OnDrop: func(m *Message) { ch <- c.Err() },
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+		},
+	})
+	tc.send(&packet.Publish{QoS: packet.QAtLeastOnce, PacketID: 1, TopicName: "a", Payload: []byte("a")})
+	tc.recv()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Disconnect(true)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Disconnect is blocked")
+	}
+	if err := <-ch; err != Explicitly {
+		t.Fatalf("unexpected Err: %v", err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
 	"log"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,19 +25,62 @@ type Client interface {
 	// Ping sends a PING packet.
 	Ping() error
 
+	// PingContext sends a PING packet, and waits PINGRESP until ctx is done.
+	PingContext(ctx context.Context) error
+
 	// Subscribe subsribes to topics.
 	Subscribe(topics []Topic) error
 
+	// SubscribeContext subsribes to topics, and waits SUBACK until ctx is
+	// done.
+	SubscribeContext(ctx context.Context, topics []Topic) error
+
 	// Unsubscribe unsubscribes from topics.
 	Unsubscribe(topics []string) error
 
+	// UnsubscribeContext unsubscribes from topics, and waits UNSUBACK until
+	// ctx is done.
+	UnsubscribeContext(ctx context.Context, topics []string) error
+
 	// Publish publishes a message to MQTT broker.
 	Publish(qos QoS, retain bool, topic string, msg []byte) error
 
+	// PublishContext publishes a message to MQTT broker, and waits
+	// acknowledgements for QoS 1 and 2 until ctx is done.
+	PublishContext(ctx context.Context, qos QoS, retain bool, topic string, msg []byte) error
+
+	// PublishAsync publishes a message to MQTT broker without waiting
+	// acknowledgements, and returns a Token to wait them.  Number of
+	// unacknowledged QoS 1 and 2 messages is limited by Options.MaxInflight.
+	PublishAsync(ctx context.Context, qos QoS, retain bool, topic string, msg []byte) *Token
+
 	// Read returns a message if it was available.
 	// If any messages are unavailable, this blocks until message would be
 	// available when block is true, and this returns nil when block is false.
 	Read(block bool) (*Message, error)
+
+	// ReadContext returns a message.  If any messages are unavailable, this
+	// blocks until message would be available or ctx is done.
+	ReadContext(ctx context.Context) (*Message, error)
+
+	// Messages returns a channel which receives messages.  The channel is
+	// closed when ctx is done or the client is disconnected.  Use Err to
+	// get the disconnect reason.
+	Messages(ctx context.Context) <-chan *Message
+
+	// MessageSeq returns an iterator over messages.  The iteration ends
+	// with an error, which is ctx's error or the disconnect reason.
+	MessageSeq(ctx context.Context) iter.Seq2[*Message, error]
+
+	// Err returns the disconnect reason, or nil while connected.
+	Err() error
+
+	// Stats returns a snapshot of statistics.
+	Stats() Stats
+
+	// Addr returns URL of the broker which is connected currently or last.
+	// It is empty when connected by ConnectConn.
+	Addr() string
 }
 
 var (
@@ -49,72 +94,127 @@ var (
 // client implements a simple MQTT client.
 type client struct {
 	conn net.Conn
+	addr string // URL of the connected broker
 	quit chan bool
-	r    packet.Reader
+	r    *packetReader
 	p    Param
 	log  *log.Logger
 
+	// ctx is canceled when the client is terminated.
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	sl   sync.Mutex // send (conn) lock
 	id   uint32
+	term bool // true when the client is terminated
 	derr error
 
-	ping  *waitop.WaitOp
-	subsc *waitop.WaitOp
-	unsub *waitop.WaitOp
+	ping *waitop.WaitOp
 
 	// message receive buffer.
-	msgc *sync.Cond
-	msgs []*Message
-	msgr int
-	msgw int
+	mb *buffer
 
 	publock sync.Mutex
 
 	// auto keep aliving
 	kd time.Duration
+	kg time.Duration // grace period to wait PINGRESP
 	kl sync.Mutex
 	kx chan struct{}
+	kq chan bool    // quit of a connection which is timed out
+	lr atomic.Int64 // last time when a packet was received
 
 	wl sync.RWMutex
 	wt map[packet.ID]*waitop.WaitOp
+
+	// inf tracks in-flight publishes.
+	inf *inflights
+
+	stats stats
+
+	// packets to be retried.
+	rl sync.Mutex
+	rm map[packet.ID]*retryEntry
+	rs uint64
+
+	// st stores in-flight packets.
+	st Store
+
+	// q is the offline queue, nil when it is disabled.
+	q *queue
+
+	// current subscriptions to restore on reconnect.
+	tl sync.Mutex
+	tm map[string]Topic
+
+	// message handlers for each topic filter.
+	hl sync.RWMutex
+	hm map[string]*handler
 }
 
 var _ Client = (*client)(nil)
 
-func (c *client) Disconnect(force bool) error {
+func (c *client) Addr() string {
 	c.sl.Lock()
 	defer c.sl.Unlock()
-	if c.conn == nil {
+	return c.addr
+}
+
+func (c *client) Disconnect(force bool) error {
+	c.sl.Lock()
+	if c.term {
+		c.sl.Unlock()
 		return nil
 	}
-	if !force {
-		b, _ := (&packet.Disconnect{}).Encode()
-		c.sendRaw(b)
+	if !force && c.conn != nil {
+		c.sendPacket(&packet.Disconnect{})
 	}
-	return c.stopRaw(Explicitly)
+	dropped, err := c.stopRaw(Explicitly)
+	c.sl.Unlock()
+	c.dropMessages(dropped)
+	return err
 }
 
 func (c *client) Ping() error {
-	_, err := c.ping.Do(func() error {
+	return c.PingContext(context.Background())
+}
+
+func (c *client) PingContext(ctx context.Context) error {
+	start := time.Now()
+	_, err := c.ping.DoContext(ctx, func() error {
 		return c.send(&packet.PingReq{})
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	c.updatePingRTT(time.Since(start))
+	return nil
 }
 
 func (c *client) Subscribe(topics []Topic) error {
-	var id packet.ID
-	r, err := c.subsc.Do(func() error {
-		array, err := packetTopics(topics)
-		if err != nil {
-			return err
-		}
-		id = c.emitID()
+	return c.SubscribeContext(context.Background(), topics)
+}
+
+func (c *client) SubscribeContext(ctx context.Context, topics []Topic) error {
+	array, err := packetTopics(topics)
+	if err != nil {
+		return err
+	}
+	id := c.emitID()
+	w, err := c.newWaitOp(id)
+	if err != nil {
+		return err
+	}
+	defer c.closeWaitOp(id)
+	replaced := c.addHandlers(topics)
+	r, err := w.DoContext(ctx, func() error {
 		return c.send(&packet.Subscribe{
 			PacketID: id,
 			Topics:   array,
 		})
 	})
 	if err != nil {
+		c.restoreHandlers(replaced, filters(topics))
 		return err
 	}
 	p, ok := r.(*packet.SubACK)
@@ -133,6 +233,8 @@ func (c *client) Subscribe(topics []Topic) error {
 	for i, r := range p.Results {
 		se.ResultQoS[i] = toQoS(r)
 	}
+	c.addSubscriptions(topics, se.ResultQoS)
+	c.restoreHandlers(replaced, failedFilters(topics, se.ResultQoS))
 	if se.hasErrors() {
 		return se
 	}
@@ -140,9 +242,17 @@ func (c *client) Subscribe(topics []Topic) error {
 }
 
 func (c *client) Unsubscribe(topics []string) error {
-	var id packet.ID
-	r, err := c.unsub.Do(func() error {
-		id = c.emitID()
+	return c.UnsubscribeContext(context.Background(), topics)
+}
+
+func (c *client) UnsubscribeContext(ctx context.Context, topics []string) error {
+	id := c.emitID()
+	w, err := c.newWaitOp(id)
+	if err != nil {
+		return err
+	}
+	defer c.closeWaitOp(id)
+	r, err := w.DoContext(ctx, func() error {
 		return c.send(&packet.Unsubscribe{
 			PacketID: id,
 			Topics:   topics,
@@ -161,93 +271,116 @@ func (c *client) Unsubscribe(topics []string) error {
 	if ue.hasErrors() {
 		return ue
 	}
+	c.removeSubscriptions(topics)
+	c.removeHandlers(topics)
 	return nil
 }
 
 func (c *client) Publish(qos QoS, retain bool, topic string, msg []byte) error {
-	// FIXME: support ExactlyOnce QoS
+	return c.PublishContext(context.Background(), qos, retain, topic, msg)
+}
+
+func (c *client) PublishContext(ctx context.Context, qos QoS, retain bool, topic string, msg []byte) error {
 	switch qos {
 	case AtMostOnce:
-		return c.publish0(retain, topic, msg)
+		return c.publish0(ctx, retain, topic, msg)
 	case AtLeastOnce:
-		return c.Publish1(context.Background(), retain, topic, msg)
+		return c.Publish1(ctx, retain, topic, msg)
+	case ExactlyOnce:
+		return c.Publish2(ctx, retain, topic, msg)
 	default:
 		return errors.New("unsupported QoS")
 	}
 }
 
 func (c *client) Read(block bool) (*Message, error) {
-	c.msgc.L.Lock()
-	for c.msgr == c.msgw {
-		if !block {
-			c.msgc.L.Unlock()
-			return nil, nil
-		}
-		c.msgc.Wait()
-	}
-	m := c.msgs[c.msgr]
-	c.msgs[c.msgr] = nil
-	if m != nil {
-		if c.msgr++; c.msgr >= len(c.msgs) {
-			c.msgr = 0
-		}
-	}
-	c.msgc.L.Unlock()
-	if m == nil {
-		return nil, ErrTerminated
+	if !block {
+		return c.read(nil)
 	}
-	return m, nil
+	return c.read(context.Background())
+}
+
+func (c *client) ReadContext(ctx context.Context) (*Message, error) {
+	return c.read(ctx)
+}
+
+// read reads a message from ring buffer.  It doesn't wait when ctx is nil.
+func (c *client) read(ctx context.Context) (*Message, error) {
+	return c.mb.get(ctx)
 }
 
 func (c *client) start() {
 	c.ping = waitop.New()
-	c.subsc = waitop.New()
-	c.unsub = waitop.New()
-	c.msgc = sync.NewCond(new(sync.Mutex))
-	c.msgs = make([]*Message, 32)
-	if !c.p.options().DisableAutoKeepAlive {
-		go c.keepAliveLoop()
+	o := c.p.options()
+	c.mb = newBuffer(o.readBufferSize(), o.ReadBufferPolicy)
+	c.run()
+}
+
+// run starts goroutines for current connection.
+func (c *client) run() {
+	now := time.Now().UnixNano()
+	c.lr.Store(now)
+	c.stats.connectedAt.Store(now)
+	if o := c.p.options(); !o.DisableAutoKeepAlive && o.KeepAlive > 0 {
+		go c.keepAliveLoop(c.quit)
 	}
-	go c.recvLoop()
+	if c.p.options().RetryInterval > 0 {
+		go c.retryLoop(c.quit)
+	}
+	go c.recvLoop(c.quit, c.r)
 }
 
 // stop closes connection and remove all resources.
 func (c *client) stop(reason error) error {
 	c.sl.Lock()
-	defer c.sl.Unlock()
-	return c.stopRaw(reason)
+	dropped, err := c.stopRaw(reason)
+	c.sl.Unlock()
+	c.dropMessages(dropped)
+	return err
 }
 
-func (c *client) stopRaw(reason error) error {
-	if c.conn == nil {
-		return nil
+// stopRaw terminates the client, and returns buffered messages which are not
+// read.  They should be dropped by dropMessages after c.sl is unlocked,
+// because callbacks may call methods of the client.
+func (c *client) stopRaw(reason error) ([]*Message, error) {
+	if c.term {
+		return nil, nil
+	}
+	c.term = true
+	c.cancel()
+	c.stats.connectedAt.Store(0)
+	var err error
+	if c.conn != nil {
+		close(c.quit)
+		err = c.conn.Close()
+		c.conn = nil
 	}
-	close(c.quit)
-	err := c.conn.Close()
-	c.conn = nil
 	c.ping.Close()
-	c.subsc.Close()
-	c.unsub.Close()
+	if c.q != nil {
+		for _, p := range c.q.close() {
+			c.logDroppedPublish(p, reason)
+		}
+	}
+	c.closeAllWaitOps()
+	c.inf.close(ErrTerminated)
 	if c.derr == nil {
 		c.derr = reason
 	}
 	// clear all messages
-	c.msgc.L.Lock()
-	for i, m := range c.msgs {
-		if m != nil {
-			c.logDroppedMessage(m)
-		}
-		c.msgs[i] = nil
-	}
-	c.msgr = 0
-	c.msgw = 1
-	c.msgc.Signal()
-	c.msgc.L.Unlock()
-	return err
+	return c.mb.close(), err
 }
 
 func (c *client) sendRaw(b []byte) error {
+	if d := c.p.options().WriteTimeout; d > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(d))
+	}
 	_, err := c.conn.Write(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// the connection may be broken by partial write.
+		c.kq = c.quit
+		c.conn.Close()
+		return Timeout
+	}
 	return err
 }
 
@@ -257,21 +390,37 @@ func (c *client) send(p packet.Packet) error {
 	if c.conn == nil {
 		return errors.New("connection closed")
 	}
+	if err := c.sendPacket(p); err != nil {
+		return err
+	}
+	c.keepAliveExtend()
+	return nil
+}
+
+// sendPacket sends a packet with applying Interceptors.  It should be called
+// with sl locked.
+func (c *client) sendPacket(p packet.Packet) error {
+	o := c.p.options()
+	p, err := o.preSend(p)
+	if err != nil || p == nil {
+		return err
+	}
 	b, err := p.Encode()
 	if err != nil {
 		return err
 	}
-	err = c.sendRaw(b)
-	if err != nil {
+	if err := c.sendRaw(b); err != nil {
 		return err
 	}
-	c.keepAliveExtend()
+	c.countSent(b)
+	o.postSend(p)
 	return nil
 }
 
-func (c *client) keepAliveLoop() {
+func (c *client) keepAliveLoop(quit chan bool) {
 	c.kl.Lock()
-	c.kx = make(chan struct{})
+	kx := make(chan struct{})
+	c.kx = kx
 	ti := time.NewTimer(c.kd)
 	tistop := func() {
 		if !ti.Stop() {
@@ -279,15 +428,18 @@ func (c *client) keepAliveLoop() {
 		}
 	}
 	c.kl.Unlock()
+	// rt checks silence of read side, because sending packets suppress PINGREQ.
+	rt := time.NewTicker(c.kd)
+	defer rt.Stop()
 
 	needStop := true
 loop:
 	for {
 		select {
-		case <-c.quit:
+		case <-quit:
 			tistop()
 			break loop
-		case <-c.kx:
+		case <-kx:
 			if needStop {
 				tistop()
 			}
@@ -295,17 +447,53 @@ loop:
 			needStop = true
 		case <-ti.C:
 			needStop = false
-			go c.Ping()
+			go c.keepAlivePing(quit)
 			// c.keepAliveExtend() will be called and resumed the Timer by
 			// c.send() when sending Ping packet.
+		case <-rt.C:
+			if time.Since(time.Unix(0, c.lr.Load())) >= c.kd {
+				go c.keepAlivePing(quit)
+			}
 		}
 	}
 	c.kl.Lock()
-	close(c.kx)
-	c.kx = nil
+	// c.kx may be replaced by keepAliveLoop for a new connection.
+	if c.kx == kx {
+		c.kx = nil
+	}
+	close(kx)
 	c.kl.Unlock()
 }
 
+// keepAlivePing sends PINGREQ, then closes the connection as timed out
+// when neither PINGRESP nor other packets are received in grace period.
+func (c *client) keepAlivePing(quit chan bool) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.kg)
+	defer cancel()
+	err := c.PingContext(ctx)
+	if err != context.DeadlineExceeded {
+		return
+	}
+	if time.Since(time.Unix(0, c.lr.Load())) < c.kg {
+		return
+	}
+	c.sl.Lock()
+	defer c.sl.Unlock()
+	if c.quit != quit || c.conn == nil {
+		return
+	}
+	c.logKeepAliveTimeout()
+	c.kq = quit
+	c.conn.Close()
+}
+
+// timedOut checks the connection is closed by keep alive timeout.
+func (c *client) timedOut(quit chan bool) bool {
+	c.sl.Lock()
+	defer c.sl.Unlock()
+	return c.kq == quit
+}
+
 func (c *client) keepAliveExtend() {
 	c.kl.Lock()
 	if c.kx != nil {
@@ -317,30 +505,52 @@ func (c *client) keepAliveExtend() {
 	c.kl.Unlock()
 }
 
-func (c *client) recvLoop() {
+func (c *client) recvLoop(quit chan bool, r *packetReader) {
+	err := c.recvPackets(r)
+	if c.timedOut(quit) {
+		err = Timeout
+	}
+	if c.drop(quit) && c.reconnect(err) {
+		return
+	}
+	c.stop(err)
+	if c.p.OnDisconnect != nil {
+		c.p.OnDisconnect(c.derr, c.p)
+	}
+	c.emitStateChange(Disconnected, c.derr)
+}
+
+// recvPackets receives and dispatches packets until an error occurs.
+func (c *client) recvPackets(r *packetReader) error {
 	delay := backoff.Exp{Min: time.Millisecond * 5}
-loop:
 	for {
-		p, err := packet.SplitDecode(c.r)
+		b, err := r.readPacket()
 		if err != nil {
 			if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
 				c.logTemporaryError(nerr)
 				delay.Wait()
 				continue
 			}
-			c.stop(err)
-			break loop
+			return err
 		}
 		delay.Reset()
+		c.lr.Store(time.Now().UnixNano())
+		c.countReceived(b)
+		p, err := packet.Decode(b)
+		if err != nil {
+			return err
+		}
+		p, err = c.p.options().preProcess(p)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
 		if err := c.dispatch(p); err != nil {
-			c.stop(err)
-			break loop
+			return err
 		}
 	}
-	if c.p.OnDisconnect != nil {
-		c.p.OnDisconnect(c.derr, c.p)
-	}
-	c.r = nil
 }
 
 // dispatch dispatches received packet.
@@ -349,11 +559,17 @@ func (c *client) dispatch(raw packet.Packet) error {
 	case *packet.Publish:
 		return c.procPublish(p)
 	case *packet.PubACK:
-		c.doneWaitOp(p.PacketID)
+		return c.procPubACK(p)
+	case *packet.PubRec:
+		return c.procPubRec(p)
+	case *packet.PubComp:
+		return c.procPubComp(p)
+	case *packet.PubRel:
+		return c.procPubRel(p)
 	case *packet.SubACK:
-		c.subsc.Fulfill(p)
+		c.doneWaitOp(p.PacketID, p)
 	case *packet.UnsubACK:
-		c.unsub.Fulfill(p)
+		c.doneWaitOp(p.PacketID, p)
 	case *packet.PingResp:
 		c.ping.Fulfill(p)
 	default:
@@ -362,39 +578,26 @@ func (c *client) dispatch(raw packet.Packet) error {
 	return nil
 }
 
-func (c *client) publish0(retain bool, topic string, msg []byte) error {
+func (c *client) publish0(ctx context.Context, retain bool, topic string, msg []byte) error {
 	p := &packet.Publish{
 		QoS:       AtMostOnce.qos(),
 		Retain:    retain,
 		TopicName: topic,
 		Payload:   msg,
 	}
-	return c.send(p)
+	return c.sendPublish(ctx, p)
 }
 
 // Publish1 publishes a message with QoS=1 (at least once). This blocks until
 // receive PubACK or context is exceeded.
 func (c *client) Publish1(ctx context.Context, retain bool, topic string, msg []byte) error {
-	id := c.emitID()
-	w, err := c.newWaitOp(id)
-	if err != nil {
-		return err
-	}
-	defer c.closeWaitOp(id)
-	// FIXME: support context
-	_, err = w.Do(func() error {
-		return c.send(&packet.Publish{
-			QoS:       AtLeastOnce.qos(),
-			Retain:    retain,
-			TopicName: topic,
-			PacketID:  id,
-			Payload:   msg,
-		})
-	})
-	if err != nil {
-		return err
-	}
-	return nil
+	return c.publishWait(ctx, AtLeastOnce, retain, topic, msg)
+}
+
+// Publish2 publishes a message with QoS=2 (exactly once). This blocks until
+// receive PubComp or context is exceeded.
+func (c *client) Publish2(ctx context.Context, retain bool, topic string, msg []byte) error {
+	return c.publishWait(ctx, ExactlyOnce, retain, topic, msg)
 }
 
 func (c *client) newWaitOp(id packet.ID) (*waitop.WaitOp, error) {
@@ -408,7 +611,7 @@ func (c *client) newWaitOp(id packet.ID) (*waitop.WaitOp, error) {
 	return w, nil
 }
 
-func (c *client) doneWaitOp(id packet.ID) {
+func (c *client) doneWaitOp(id packet.ID, p packet.Packet) {
 	c.wl.RLock()
 	defer c.wl.RUnlock()
 	w, ok := c.wt[id]
@@ -416,7 +619,17 @@ func (c *client) doneWaitOp(id packet.ID) {
 		// FIXME: log ignore Packet ID.
 		return
 	}
-	w.Fulfill(id)
+	w.Fulfill(p)
+}
+
+func (c *client) rejectWaitOp(id packet.ID, err error) {
+	c.wl.RLock()
+	defer c.wl.RUnlock()
+	w, ok := c.wt[id]
+	if !ok {
+		return
+	}
+	w.Reject(err)
 }
 
 func (c *client) closeWaitOp(id packet.ID) {
@@ -425,6 +638,15 @@ func (c *client) closeWaitOp(id packet.ID) {
 	c.wl.Unlock()
 }
 
+// closeAllWaitOps terminates all waiting operations.
+func (c *client) closeAllWaitOps() {
+	c.wl.RLock()
+	for _, w := range c.wt {
+		w.Close()
+	}
+	c.wl.RUnlock()
+}
+
 func (c *client) emitID() packet.ID {
 	for {
 		n := uint16(atomic.AddUint32(&c.id, 1))
@@ -435,19 +657,76 @@ func (c *client) emitID() packet.ID {
 }
 
 func (c *client) procPublish(p *packet.Publish) error {
-	// parse as Message
-	var m *Message
+	m := toMessage(p)
 	switch p.QoS {
 	case packet.QAtMostOnce:
-		m = &Message{
-			Topic: p.TopicName,
-			Body:  p.Payload,
+		return c.deliver(m)
+	case packet.QAtLeastOnce:
+		if err := c.deliver(m); err != nil {
+			return err
+		}
+		return c.send(&packet.PubACK{PacketID: p.PacketID})
+	case packet.QExactlyOnce:
+		// deliver only first one, and suppress duplicates until PUBREL.
+		received, err := c.st.HasIncoming(p.PacketID)
+		if err != nil {
+			return err
 		}
+		if !received {
+			if err := c.deliver(m); err != nil {
+				return err
+			}
+			if err := c.st.PutIncoming(p.PacketID); err != nil {
+				return err
+			}
+		}
+		return c.send(&packet.PubRec{PacketID: p.PacketID})
 	default:
 		// unsupported QoS.
 		return errors.New("unsupported QoS")
 	}
-	if m == nil {
+}
+
+func (c *client) procPubRel(p *packet.PubRel) error {
+	if err := c.st.DeleteIncoming(p.PacketID); err != nil {
+		return err
+	}
+	return c.send(&packet.PubComp{PacketID: p.PacketID})
+}
+
+func (c *client) procPubACK(p *packet.PubACK) error {
+	c.forget(p.PacketID)
+	if err := c.st.DeleteOutgoing(p.PacketID); err != nil {
+		return err
+	}
+	c.inf.ack(p.PacketID, p)
+	return nil
+}
+
+// procPubRec sends PUBREL for QoS2 message, even if no one is waiting it.
+func (c *client) procPubRec(p *packet.PubRec) error {
+	rel := &packet.PubRel{PacketID: p.PacketID}
+	if err := c.st.PutOutgoing(p.PacketID, rel); err != nil {
+		return err
+	}
+	c.markSent(p.PacketID, rel)
+	return c.send(rel)
+}
+
+func (c *client) procPubComp(p *packet.PubComp) error {
+	c.forget(p.PacketID)
+	if err := c.st.DeleteOutgoing(p.PacketID); err != nil {
+		return err
+	}
+	c.inf.ack(p.PacketID, p)
+	return nil
+}
+
+// deliver passes a message to handlers for topics, OnPublish or the ring
+// buffer.
+func (c *client) deliver(m *Message) error {
+	if list := c.matchHandlers(m.Topic); len(list) > 0 {
+		go c.emitHandlers(list, m)
 		return nil
 	}
 	if c.p.OnPublish != nil {
@@ -459,26 +738,27 @@ func (c *client) procPublish(p *packet.Publish) error {
 
 // put puts a message to ring buffer.
 func (c *client) put(m *Message) error {
-	c.msgc.L.Lock()
-	c.msgs[c.msgw] = m
-	if c.msgw++; c.msgw >= len(c.msgs) {
-		c.msgw = 0
-	}
-	var dropped *Message
-	if c.msgw == c.msgr {
-		dropped = c.msgs[c.msgr]
-		if c.msgr++; c.msgr >= len(c.msgs) {
-			c.msgr = 0
-		}
-	}
-	c.msgc.Signal()
-	c.msgc.L.Unlock()
-	if dropped != nil {
-		c.logDroppedMessage(dropped)
+	if dropped := c.mb.put(m); dropped != nil {
+		c.dropMessage(dropped)
 	}
 	return nil
 }
 
+// dropMessage notifies a message dropped from ring buffer.
+func (c *client) dropMessages(messages []*Message) {
+	for _, m := range messages {
+		c.dropMessage(m)
+	}
+}
+
+func (c *client) dropMessage(m *Message) {
+	c.logDroppedMessage(m)
+	c.countDropped()
+	if c.p.OnDrop != nil {
+		c.p.OnDrop(m)
+	}
+}
+
 func (c *client) emitOnPublish(m *Message) {
 	c.publock.Lock()
 	defer c.publock.Unlock()
@@ -492,6 +772,13 @@ func (c *client) logTemporaryError(nerr net.Error) {
 	c.log.Printf("temporal error: %v", nerr)
 }
 
+func (c *client) logKeepAliveTimeout() {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("keep alive timeout: no response in %s", c.kg)
+}
+
 func (c *client) logDroppedMessage(m *Message) {
 	if c.log == nil {
 		return
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestPublish2(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.Publish2(context.Background(), false, "a/b", []byte("hello"))
+	}()
+
+	p, ok := tc.recv().(*packet.Publish)
+	if !ok {
+		t.Fatal("not PUBLISH")
+	}
+	if p.QoS != packet.QExactlyOnce || p.TopicName != "a/b" || string(p.Payload) != "hello" {
+		t.Fatalf("unexpected PUBLISH: %+v", p)
+	}
+	tc.send(&packet.PubRec{PacketID: p.PacketID})
+	rel, ok := tc.recv().(*packet.PubRel)
+	if !ok || rel.PacketID != p.PacketID {
+		t.Fatalf("unexpected PUBREL: %+v", rel)
+	}
+	// resent PUBREC should be answered by PUBREL again.
+	tc.send(&packet.PubRec{PacketID: p.PacketID})
+	rel, ok = tc.recv().(*packet.PubRel)
+	if !ok || rel.PacketID != p.PacketID {
+		t.Fatalf("unexpected PUBREL: %+v", rel)
+	}
+	tc.send(&packet.PubComp{PacketID: p.PacketID})
+
+	if err := <-ch; err != nil {
+		t.Fatalf("Publish2 failed: %s", err)
+	}
+}
+
+func TestReceiveQoS1(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	tc.send(&packet.Publish{
+		QoS:       packet.QAtLeastOnce,
+		TopicName: "a/b",
+		PacketID:  123,
+		Payload:   []byte("qos1"),
+	})
+	ack, ok := tc.recv().(*packet.PubACK)
+	if !ok || ack.PacketID != 123 {
+		t.Fatalf("unexpected PUBACK: %+v", ack)
+	}
+	m, err := c.Read(true)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if m.Topic != "a/b" || string(m.Body) != "qos1" {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+}
+
+func TestReceiveMetadata(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	before := time.Now()
+	tc.send(&packet.Publish{
+		Dup:       true,
+		QoS:       packet.QAtLeastOnce,
+		Retain:    true,
+		TopicName: "a/b",
+		PacketID:  123,
+		Payload:   []byte("retained"),
+	})
+	tc.recv()
+	m, err := c.Read(true)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if !m.Dup || m.QoS != AtLeastOnce || !m.Retain || m.PacketID != 123 {
+		t.Fatalf("unexpected metadata: %+v", m)
+	}
+	if m.ReceivedAt.Before(before) || m.ReceivedAt.After(time.Now()) {
+		t.Fatalf("unexpected ReceivedAt: %s", m.ReceivedAt)
+	}
+}
+
+func TestReceiveQoS2(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	p := &packet.Publish{
+		QoS:       packet.QExactlyOnce,
+		TopicName: "a/b",
+		PacketID:  456,
+		Payload:   []byte("qos2"),
+	}
+	tc.send(p)
+	rec, ok := tc.recv().(*packet.PubRec)
+	if !ok || rec.PacketID != 456 {
+		t.Fatalf("unexpected PUBREC: %+v", rec)
+	}
+	// duplicated PUBLISH should be acknowledged but not delivered.
+	p.Dup = true
+	tc.send(p)
+	rec, ok = tc.recv().(*packet.PubRec)
+	if !ok || rec.PacketID != 456 {
+		t.Fatalf("unexpected PUBREC: %+v", rec)
+	}
+	tc.send(&packet.PubRel{PacketID: 456})
+	comp, ok := tc.recv().(*packet.PubComp)
+	if !ok || comp.PacketID != 456 {
+		t.Fatalf("unexpected PUBCOMP: %+v", comp)
+	}
+
+	m, err := c.Read(false)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if m == nil || m.Topic != "a/b" || string(m.Body) != "qos2" {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+	m, err = c.Read(false)
+	if err != nil || m != nil {
+		t.Fatalf("duplicated message delivered: %+v %v", m, err)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	c, _ := connectTestBroker(t, Param{})
+	// the broker never answers.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.PingContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("PingContext returns unexpected error: %v", err)
+	}
+	if err := c.SubscribeContext(ctx, []Topic{{Filter: "a/b"}}); err != context.DeadlineExceeded {
+		t.Errorf("SubscribeContext returns unexpected error: %v", err)
+	}
+	if err := c.PublishContext(ctx, AtLeastOnce, false, "a/b", nil); err != context.DeadlineExceeded {
+		t.Errorf("PublishContext returns unexpected error: %v", err)
+	}
+	if _, err := c.ReadContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("ReadContext returns unexpected error: %v", err)
+	}
+	c.wl.RLock()
+	n := len(c.wt)
+	c.wl.RUnlock()
+	if n != 0 {
+		t.Errorf("wait-ops are left: %d", n)
+	}
+	if n := c.inf.len(); n != 0 {
+		t.Errorf("in-flight publishes are left: %d", n)
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	b := newTestBroker(t)
+	ch := make(chan net.Conn, 1)
+	go func() {
+		// accept a connection but never send CONNACK.
+		conn, _ := b.l.Accept()
+		ch <- conn
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := ConnectContext(ctx, Param{ID: "testclient", Addr: b.addr()})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("ConnectContext returns unexpected error: %v", err)
+	}
+	if conn := <-ch; conn != nil {
+		conn.Close()
+	}
+}
+
+func TestConnACKTimeout(t *testing.T) {
+	b := newTestBroker(t)
+	ch := make(chan net.Conn, 1)
+	go func() {
+		// accept a connection but never send CONNACK.
+		conn, _ := b.l.Accept()
+		ch <- conn
+	}()
+	_, err := Connect(Param{
+		ID:      "testclient",
+		Addr:    b.addr(),
+		Options: &Options{ConnACKTimeout: 50 * time.Millisecond},
+	})
+	if err != Timeout {
+		t.Fatalf("Connect returns unexpected error: %v", err)
+	}
+	if conn := <-ch; conn != nil {
+		conn.Close()
+	}
+}
+
+func TestConnectTimeoutTLS(t *testing.T) {
+	b := newTestBroker(t)
+	ch := make(chan net.Conn, 1)
+	go func() {
+		// accept a connection but never answer TLS handshake.
+		conn, _ := b.l.Accept()
+		ch <- conn
+	}()
+	start := time.Now()
+	_, err := Connect(Param{
+		ID:      "testclient",
+		Addr:    "tls://" + b.l.Addr().String(),
+		Options: &Options{ConnectTimeout: 50 * time.Millisecond},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Connect returns unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("Connect took too long: %s", d)
+	}
+	if conn := <-ch; conn != nil {
+		conn.Close()
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	ch := make(chan error, 1)
+	_, tc := connectTestBroker(t, Param{
+		OnDisconnect: func(reason error, _ Param) { ch <- reason },
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			ReadTimeout:          50 * time.Millisecond,
+		},
+	})
+	// idle longer than ReadTimeout is allowed between packets.
+	time.Sleep(100 * time.Millisecond)
+	tc.send(&packet.PingResp{})
+	// send a partial packet.
+	b, _ := (&packet.Publish{TopicName: "a", Payload: []byte("a")}).Encode()
+	tc.conn.Write(b[:3])
+	select {
+	case reason := <-ch:
+		if reason != Timeout {
+			t.Fatalf("unexpected reason: %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read timeout is not detected")
+	}
+}
+
+func TestSubscribeConcurrently(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	filters := []string{"a/#", "b/#", "c/#"}
+	ch := make(chan error, len(filters))
+	for _, f := range filters {
+		go func() {
+			ch <- c.Subscribe([]Topic{{Filter: f, QoS: AtLeastOnce}})
+		}()
+	}
+	var reqs []*packet.Subscribe
+	for range filters {
+		p, ok := tc.recv().(*packet.Subscribe)
+		if !ok {
+			t.Fatal("not SUBSCRIBE")
+		}
+		reqs = append(reqs, p)
+	}
+	// reply SUBACKs in reverse order.
+	for i := len(reqs) - 1; i >= 0; i-- {
+		tc.send(&packet.SubACK{
+			PacketID: reqs[i].PacketID,
+			Results:  []packet.SubscribeResult{packet.SubscribeAtLeastOnce},
+		})
+	}
+	for range filters {
+		if err := <-ch; err != nil {
+			t.Errorf("Subscribe failed: %s", err)
+		}
+	}
+
+	ch2 := make(chan error, 2)
+	for _, f := range filters[:2] {
+		go func() {
+			ch2 <- c.Unsubscribe([]string{f})
+		}()
+	}
+	p1 := tc.recv().(*packet.Unsubscribe)
+	p2 := tc.recv().(*packet.Unsubscribe)
+	tc.send(&packet.UnsubACK{PacketID: p2.PacketID})
+	tc.send(&packet.UnsubACK{PacketID: p1.PacketID})
+	for range 2 {
+		if err := <-ch2; err != nil {
+			t.Errorf("Unsubscribe failed: %s", err)
+		}
+	}
+}
+
+func TestReconnectResend(t *testing.T) {
+	states := make(chan State, 10)
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			AutoReconnect:        true,
+			ReconnectMinDelay:    10 * time.Millisecond,
+		},
+		OnStateChange: func(s State, err error) {
+			states <- s
+		},
+	})
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.Publish(AtLeastOnce, false, "a/b", []byte("hello"))
+	}()
+	p1, ok := tc.recv().(*packet.Publish)
+	if !ok {
+		t.Fatal("not PUBLISH")
+	}
+	if p1.Dup {
+		t.Fatal("DUP flag is set for first PUBLISH")
+	}
+
+	// lost the connection before PUBACK.
+	tc.conn.Close()
+	if s := <-states; s != Reconnecting {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	tc = tc.b.accept(nil)
+	if s := <-states; s != Connected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	p2, ok := tc.recv().(*packet.Publish)
+	if !ok {
+		t.Fatal("not PUBLISH")
+	}
+	if !p2.Dup || p2.PacketID != p1.PacketID || string(p2.Payload) != "hello" {
+		t.Fatalf("unexpected resent PUBLISH: %+v", p2)
+	}
+	tc.send(&packet.PubACK{PacketID: p2.PacketID})
+	if err := <-ch; err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+
+	c.Disconnect(false)
+	if s := <-states; s != Disconnected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	ch := make(chan error, 1)
+	_, tc := connectTestBroker(t, Param{
+		OnDisconnect: func(reason error, _ Param) { ch <- reason },
+		Options: &Options{
+			KeepAlive:      1,
+			KeepAliveGrace: 200 * time.Millisecond,
+		},
+	})
+	// respond to the first PINGREQ only.
+	if _, ok := tc.recv().(*packet.PingReq); !ok {
+		t.Fatal("PINGREQ is not received")
+	}
+	tc.send(&packet.PingResp{})
+	if _, ok := tc.recv().(*packet.PingReq); !ok {
+		t.Fatal("PINGREQ is not received")
+	}
+	select {
+	case reason := <-ch:
+		if reason != Timeout {
+			t.Fatalf("unexpected reason: %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout is not detected")
+	}
+}
@@ -1,126 +1,235 @@
 package client
 
 import (
-	"crypto/tls"
+	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"net"
-	"net/url"
+	"os"
+	"time"
 
 	"github.com/koron/go-mqtt/internal/waitop"
 	"github.com/koron/go-mqtt/packet"
-	"golang.org/x/net/websocket"
 )
 
 // Connect connects to MQTT broker and returns a Client.
 func Connect(p Param) (Client, error) {
-	c, err := dial(p)
+	return ConnectContext(context.Background(), p)
+}
+
+// ConnectContext connects to MQTT broker and returns a Client.
+// ctx is used to cancel dialing and CONNECT/CONNACK handshake.
+func ConnectContext(ctx context.Context, p Param) (Client, error) {
+	l, err := connect(ctx, p, "")
 	if err != nil {
 		return nil, err
 	}
-	r := p.newPacketReader(c)
+	return newClient(l, p)
+}
 
-	// send CONNECT packet.
-	bc, err := p.connectPacket().Encode()
+// ConnectConn connects to MQTT broker over an established connection conn,
+// and returns a Client.  The conn is closed when failed.  Reconnection by
+// AutoReconnect dials Param.Addr as usual.
+func ConnectConn(ctx context.Context, conn net.Conn, p Param) (Client, error) {
+	l, err := establish(ctx, conn, p)
 	if err != nil {
-		c.Close()
-		return nil, err
-	}
-	_, err = c.Write(bc)
-	if err != nil {
-		c.Close()
 		return nil, err
 	}
+	return newClient(l, p)
+}
 
-	// receive CONNACK packet.
-	rp, err := packet.SplitDecode(r)
+func newClient(l *link, p Param) (Client, error) {
+	opts := p.options()
+	st, err := restoreStore(opts, l.ack.SessionPresent)
 	if err != nil {
-		c.Close()
+		l.conn.Close()
 		return nil, err
 	}
-	ack, ok := rp.(*packet.ConnACK)
-	if !ok {
-		c.Close()
-		return nil, errors.New("received non CONNACK")
-	}
-	if ack.ReturnCode != packet.ConnectAccept {
-		return nil, ack.ReturnCode
-	}
-
-	opts := p.options()
 	cl := &client{
-		conn: c,
+		conn: l.conn,
+		addr: l.addr,
 		quit: make(chan bool, 1),
-		r:    r,
+		r:    l.r,
 		p:    p,
 		log:  opts.Logger,
 		kd:   opts.keepAliveInterval(),
+		kg:   opts.keepAliveGrace(),
 		wt:   map[packet.ID]*waitop.WaitOp{},
+		inf:  newInflights(opts.MaxInflight, opts.FailOnInflightFull),
+		rm:   map[packet.ID]*retryEntry{},
+		st:   st,
+		q:    opts.newQueue(),
+		tm:   map[string]Topic{},
+		hm:   map[string]*handler{},
+	}
+	cl.ctx, cl.cancel = context.WithCancel(context.Background())
+	// avoid to conflict packet IDs with stored packets.
+	if packets, err := st.Outgoings(); err == nil {
+		for _, sp := range packets {
+			if id := uint32(packetID(sp)); id > cl.id {
+				cl.id = id
+			}
+		}
 	}
 	cl.start()
+	if !opts.CleanSession && l.ack.SessionPresent {
+		// continue to send packets which stored in previous process.
+		cl.resend()
+	}
 	return cl, nil
 }
 
-func dial(p Param) (net.Conn, error) {
-	u, err := p.url()
+// restoreStore prepares Store for a new client.  With CleanSession, all
+// stored state is discarded.
+func restoreStore(opts *Options, sessionPresent bool) (Store, error) {
+	st := opts.store()
+	if opts.CleanSession {
+		return st, st.Reset()
+	}
+	if !sessionPresent {
+		// the broker lost the session, so stored packets are meaningless
+		// for it.
+		return st, st.Reset()
+	}
+	return st, nil
+}
+
+// link is an established connection to MQTT broker.
+type link struct {
+	conn net.Conn
+	r    *packetReader
+	ack  *packet.ConnACK
+	addr string // URL of the broker
+}
+
+// connect dials to MQTT brokers in turn until CONNECT/CONNACK handshake
+// succeeded.  prefer is tried at first if it is one of the brokers.
+func connect(ctx context.Context, p Param, prefer string) (*link, error) {
+	addrs := p.addrs(prefer)
+	var errs []error
+	for _, addr := range addrs {
+		l, err := connectAddr(ctx, p, addr)
+		if err == nil {
+			return l, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if len(addrs) == 1 {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func connectAddr(ctx context.Context, p Param, addr string) (*link, error) {
+	c, err := dial(ctx, p, addr)
 	if err != nil {
 		return nil, err
 	}
-	switch u.Scheme {
-	case "tcp":
-		return dialTCP(p, u)
-	case "ssl", "tcps", "tls":
-		return dialTLS(p, u)
-	case "ws":
-		return dialWS(p, u)
-	case "wss":
-		return dialWSS(p, u)
-	}
-	return nil, ErrUnknownProtocol
+	l, err := establish(ctx, c, p)
+	if err != nil {
+		return nil, err
+	}
+	l.addr = addr
+	return l, nil
 }
 
-func dialTCP(p Param, u *url.URL) (net.Conn, error) {
-	opts := p.options()
-	c, err := net.DialTimeout("tcp", u.Host, opts.ConnectTimeout)
-	if err != nil {
+// establish does CONNECT/CONNACK handshake on a connection.
+func establish(ctx context.Context, c net.Conn, p Param) (*link, error) {
+	r := p.newPacketReader(c)
+
+	// abort the handshake when ctx is done or timed out.
+	c.SetDeadline(time.Now().Add(p.options().connACKTimeout()))
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Now())
+	})
+	ack, err := handshake(ctx, c, r.r, p)
+	if !stop() || err != nil {
+		c.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, Timeout
+		}
 		return nil, err
 	}
-	return c, nil
+	c.SetDeadline(time.Time{})
+	if ack.ReturnCode != packet.ConnectAccept {
+		c.Close()
+		return nil, ack.ReturnCode
+	}
+	return &link{conn: c, r: r, ack: ack}, nil
 }
 
-func dialTLS(p Param, u *url.URL) (net.Conn, error) {
+// handshake sends CONNECT packet and receives CONNACK packet.
+func handshake(ctx context.Context, c net.Conn, r packet.Reader, p Param) (*packet.ConnACK, error) {
+	// send CONNECT packet.
 	opts := p.options()
-	c, err := tls.DialWithDialer(opts.dialer(), "tcp", u.Host, opts.TLSConfig)
+	cp, err := p.connectPacket(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return c, nil
-}
+	sp, err := opts.preSend(cp)
+	if err != nil {
+		return nil, err
+	}
+	if sp == nil {
+		return nil, errors.New("CONNECT packet is dropped by interceptor")
+	}
+	bc, err := sp.Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.Write(bc)
+	if err != nil {
+		return nil, err
+	}
+	opts.postSend(sp)
 
-func dialWS(p Param, u *url.URL) (net.Conn, error) {
-	opts := p.options()
-	cnf, err := websocket.NewConfig(u.String(), opts.wsOrigin(u))
+	// receive CONNACK packet.
+	rp, err := packet.SplitDecode(r)
 	if err != nil {
 		return nil, err
 	}
-	cnf.Dialer = opts.dialer()
-	c, err := websocket.DialConfig(cnf)
+	rp, err = opts.preProcess(rp)
 	if err != nil {
 		return nil, err
 	}
-	return c, nil
+	ack, ok := rp.(*packet.ConnACK)
+	if !ok {
+		return nil, errors.New("received non CONNACK")
+	}
+	return ack, nil
 }
 
-func dialWSS(p Param, u *url.URL) (net.Conn, error) {
-	opts := p.options()
-	cnf, err := websocket.NewConfig(u.String(), opts.wsOrigin(u))
-	if err != nil {
+// packetReader reads packets from a connection.
+type packetReader struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+// readPacket reads a datagram of a packet.  The timeout is applied after the
+// first byte of the packet arrived.
+func (pr *packetReader) readPacket() ([]byte, error) {
+	if pr.timeout <= 0 {
+		return packet.Split(pr.r)
+	}
+	if _, err := pr.r.Peek(1); err != nil {
 		return nil, err
 	}
-	cnf.Dialer = opts.dialer()
-	cnf.TlsConfig = opts.TLSConfig
-	c, err := websocket.DialConfig(cnf)
+	pr.conn.SetReadDeadline(time.Now().Add(pr.timeout))
+	b, err := packet.Split(pr.r)
 	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, Timeout
+		}
 		return nil, err
 	}
-	return c, nil
+	pr.conn.SetReadDeadline(time.Time{})
+	return b, nil
 }
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestParamAddrs(t *testing.T) {
+	p := Param{Addrs: []string{"tcp://a", "tcp://b", "tcp://c"}}
+	for _, tc := range []struct {
+		prefer string
+		want   []string
+	}{
+		{"", []string{"tcp://a", "tcp://b", "tcp://c"}},
+		{"tcp://c", []string{"tcp://c", "tcp://a", "tcp://b"}},
+		{"tcp://x", []string{"tcp://a", "tcp://b", "tcp://c"}},
+	} {
+		if got := p.addrs(tc.prefer); !slices.Equal(got, tc.want) {
+			t.Errorf("addrs(%q) mismatch: want=%v got=%v", tc.prefer, tc.want, got)
+		}
+	}
+
+	p.ShuffleAddrs = true
+	got := p.addrs("tcp://b")
+	if got[0] != "tcp://b" {
+		t.Errorf("preferred one should be first: %v", got)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, p.Addrs) {
+		t.Errorf("shuffled list mismatch: %v", got)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	b1 := newTestBroker(t)
+	b2 := newTestBroker(t)
+	states := make(chan State, 10)
+	p := Param{
+		ID:    "testclient",
+		Addrs: []string{b1.addr(), b2.addr()},
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			ConnACKTimeout:       time.Second,
+			AutoReconnect:        true,
+			ReconnectMinDelay:    10 * time.Millisecond,
+		},
+		OnStateChange: func(s State, err error) {
+			states <- s
+		},
+	}
+	type result struct {
+		c   Client
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := Connect(p)
+		ch <- result{c, err}
+	}()
+	// b1 refuses the client, so it should connect to b2.
+	b1.accept(&packet.ConnACK{ReturnCode: packet.ConnectServerUnavailable})
+	tc := b2.accept(nil)
+	r := <-ch
+	if r.err != nil {
+		t.Fatalf("Connect failed: %s", r.err)
+	}
+	defer r.c.Disconnect(true)
+	if got := r.c.Addr(); got != b2.addr() {
+		t.Fatalf("unexpected active broker: %s", got)
+	}
+
+	// reconnect to b2 at first, which is connected last time.
+	tc.conn.Close()
+	if s := <-states; s != Reconnecting {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	b2.accept(nil)
+	if s := <-states; s != Connected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	if got := r.c.Addr(); got != b2.addr() {
+		t.Fatalf("unexpected active broker: %s", got)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	var n int
+	states := make(chan State, 10)
+	_, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			AutoReconnect:        true,
+			ReconnectMinDelay:    10 * time.Millisecond,
+			Credentials: func(ctx context.Context) (string, []byte, error) {
+				n++
+				return fmt.Sprintf("user%d", n), []byte{0x00, 0xff, byte(n)}, nil
+			},
+		},
+		OnStateChange: func(s State, err error) {
+			states <- s
+		},
+	})
+	check := func(cp *packet.Connect, n byte) {
+		t.Helper()
+		if cp.Username == nil || *cp.Username != fmt.Sprintf("user%d", n) {
+			t.Errorf("unexpected username: %v", cp.Username)
+		}
+		if !bytes.Equal(cp.Password, []byte{0x00, 0xff, n}) {
+			t.Errorf("unexpected password: %v", cp.Password)
+		}
+	}
+	check(tc.connect, 1)
+
+	// fresh credentials are used for reconnection.
+	tc.conn.Close()
+	<-states
+	tc = tc.b.accept(nil)
+	if s := <-states; s != Connected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	check(tc.connect, 2)
+}
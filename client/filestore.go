@@ -0,0 +1,210 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+const (
+	fileStoreOutPrefix = "out-"
+	fileStoreInPrefix  = "in-"
+)
+
+// FileStore is a Store which holds state as files in a directory.
+// An outgoing packet is stored as "out-{ID}" file, and a packet ID of
+// incoming QoS 2 message is stored as "in-{ID}" empty file.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+	seq uint64
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a FileStore for the directory.  The directory is
+// created when it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir}
+	list, err := s.readOutgoings()
+	if err != nil {
+		return nil, err
+	}
+	for _, sp := range list {
+		if sp.seq > s.seq {
+			s.seq = sp.seq
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) name(prefix string, id packet.ID) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%05d", prefix, id))
+}
+
+// PutOutgoing stores an outgoing packet.
+func (s *FileStore) PutOutgoing(id packet.ID, p packet.Packet) error {
+	b, err := p.Encode()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.name(fileStoreOutPrefix, id)
+	sp, err := s.readOutgoing(name)
+	var seq uint64
+	switch {
+	case err == nil:
+		seq = sp.seq
+	case errors.Is(err, os.ErrNotExist):
+		s.seq++
+		seq = s.seq
+	default:
+		return err
+	}
+	d := binary.BigEndian.AppendUint64(nil, seq)
+	return writeFileAtomic(name, append(d, b...))
+}
+
+// DeleteOutgoing removes an outgoing packet.
+func (s *FileStore) DeleteOutgoing(id packet.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return removeFile(s.name(fileStoreOutPrefix, id))
+}
+
+// Outgoings returns all outgoing packets in stored order.
+func (s *FileStore) Outgoings() ([]packet.Packet, error) {
+	s.mu.Lock()
+	list, err := s.readOutgoings()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return sortStoredPackets(list), nil
+}
+
+func (s *FileStore) readOutgoings() ([]*storedPacket, error) {
+	names, err := s.list(fileStoreOutPrefix)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storedPacket, 0, len(names))
+	for _, name := range names {
+		sp, err := s.readOutgoing(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sp)
+	}
+	return list, nil
+}
+
+func (s *FileStore) readOutgoing(name string) (*storedPacket, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("broken stored packet: %s", name)
+	}
+	p, err := packet.Decode(b[8:])
+	if err != nil {
+		return nil, fmt.Errorf("broken stored packet: %s: %w", name, err)
+	}
+	return &storedPacket{seq: binary.BigEndian.Uint64(b), p: p}, nil
+}
+
+// PutIncoming records a packet ID of received QoS 2 message.
+func (s *FileStore) PutIncoming(id packet.ID) error {
+	return writeFileAtomic(s.name(fileStoreInPrefix, id), nil)
+}
+
+// DeleteIncoming removes a packet ID of received QoS 2 message.
+func (s *FileStore) DeleteIncoming(id packet.ID) error {
+	return removeFile(s.name(fileStoreInPrefix, id))
+}
+
+// HasIncoming checks a packet ID of received QoS 2 message is recorded.
+func (s *FileStore) HasIncoming(id packet.ID) (bool, error) {
+	_, err := os.Stat(s.name(fileStoreInPrefix, id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ClearIncoming removes all packet IDs of received QoS 2 messages.
+func (s *FileStore) ClearIncoming() error {
+	return s.removeAll(fileStoreInPrefix)
+}
+
+// Reset removes all stored packets and packet IDs.
+func (s *FileStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.removeAll(fileStoreOutPrefix); err != nil {
+		return err
+	}
+	return s.removeAll(fileStoreInPrefix)
+}
+
+// list lists names of files which have the prefix.
+func (s *FileStore) list(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() || !strings.HasPrefix(n, prefix) || strings.HasSuffix(n, ".tmp") {
+			continue
+		}
+		names = append(names, filepath.Join(s.dir, n))
+	}
+	return names, nil
+}
+
+func (s *FileStore) removeAll(prefix string) error {
+	names, err := s.list(prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := removeFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file via temporary file, to avoid broken file.
+func writeFileAtomic(name string, b []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func removeFile(name string) error {
+	err := os.Remove(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"github.com/koron/go-mqtt/mqtopic"
+)
+
+// handler is a message handler registered for a topic filter.
+type handler struct {
+	filter mqtopic.Filter
+	f      PublishedFunc
+}
+
+// addHandlers registers handlers of topics.  It should be called before
+// sending SUBSCRIBE, because messages may arrive soon after SUBACK.  It
+// returns replaced handlers, nil for new filters, to restore them by
+// restoreHandlers when the subscription failed.
+func (c *client) addHandlers(topics []Topic) map[string]*handler {
+	c.hl.Lock()
+	defer c.hl.Unlock()
+	replaced := map[string]*handler{}
+	for _, t := range topics {
+		if t.Handler == nil {
+			continue
+		}
+		f, err := mqtopic.ParseFilter(t.Filter)
+		if err != nil {
+			continue
+		}
+		if _, ok := replaced[t.Filter]; !ok {
+			replaced[t.Filter] = c.hm[t.Filter]
+		}
+		c.hm[t.Filter] = &handler{filter: f, f: t.Handler}
+	}
+	return replaced
+}
+
+// restoreHandlers restores handlers for filters, which were replaced by
+// addHandlers.
+func (c *client) restoreHandlers(replaced map[string]*handler, filters []string) {
+	c.hl.Lock()
+	defer c.hl.Unlock()
+	for _, f := range filters {
+		h, ok := replaced[f]
+		if !ok {
+			continue
+		}
+		if h == nil {
+			delete(c.hm, f)
+			continue
+		}
+		c.hm[f] = h
+	}
+}
+
+// removeHandlers unregisters handlers for filters.
+func (c *client) removeHandlers(filters []string) {
+	c.hl.Lock()
+	for _, f := range filters {
+		delete(c.hm, f)
+	}
+	c.hl.Unlock()
+}
+
+// matchHandlers returns handlers which match with a topic name.
+func (c *client) matchHandlers(name string) []PublishedFunc {
+	c.hl.RLock()
+	defer c.hl.RUnlock()
+	if len(c.hm) == 0 {
+		return nil
+	}
+	topic, err := mqtopic.Parse(name)
+	if err != nil {
+		return nil
+	}
+	var list []PublishedFunc
+	for _, h := range c.hm {
+		if h.filter.Match(topic) {
+			list = append(list, h.f)
+		}
+	}
+	return list
+}
+
+func (c *client) emitHandlers(list []PublishedFunc, m *Message) {
+	c.publock.Lock()
+	defer c.publock.Unlock()
+	for _, f := range list {
+		f(m)
+	}
+}
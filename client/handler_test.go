@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestHandler(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	chA := make(chan *Message, 1)
+	chB := make(chan *Message, 1)
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.Subscribe([]Topic{
+			{Filter: "a/+", Handler: func(m *Message) { chA <- m }},
+			{Filter: "b/#", Handler: func(m *Message) { chB <- m }},
+			{Filter: "c"},
+		})
+	}()
+	sub := tc.recv().(*packet.Subscribe)
+	tc.send(&packet.SubACK{
+		PacketID: sub.PacketID,
+		Results: []packet.SubscribeResult{
+			packet.SubscribeAtMostOnce,
+			packet.SubscribeAtMostOnce,
+			packet.SubscribeAtMostOnce,
+		},
+	})
+	if err := <-ch; err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+
+	for _, topic := range []string{"a/1", "b/2/3", "c"} {
+		tc.send(&packet.Publish{TopicName: topic, Payload: []byte(topic)})
+	}
+	if m := <-chA; m.Topic != "a/1" {
+		t.Errorf("unexpected message for a/+: %+v", m)
+	}
+	if m := <-chB; m.Topic != "b/2/3" {
+		t.Errorf("unexpected message for b/#: %+v", m)
+	}
+	if m, err := c.Read(true); err != nil || m.Topic != "c" {
+		t.Errorf("unexpected message for default: %+v %v", m, err)
+	}
+
+	// handler is removed by Unsubscribe.
+	go func() {
+		ch <- c.Unsubscribe([]string{"a/+"})
+	}()
+	unsub := tc.recv().(*packet.Unsubscribe)
+	tc.send(&packet.UnsubACK{PacketID: unsub.PacketID})
+	if err := <-ch; err != nil {
+		t.Fatalf("Unsubscribe failed: %s", err)
+	}
+	tc.send(&packet.Publish{TopicName: "a/1", Payload: []byte("after")})
+	if m, err := c.Read(true); err != nil || m.Topic != "a/1" {
+		t.Errorf("unexpected message for default: %+v %v", m, err)
+	}
+}
+
+func TestHandler_InvalidFilter(t *testing.T) {
+	c, _ := connectTestBroker(t, Param{})
+	err := c.Subscribe([]Topic{
+		{Filter: "a/#/b", Handler: func(m *Message) {}},
+	})
+	if err == nil {
+		t.Fatal("Subscribe should fail for invalid filter")
+	}
+}
+
+func TestHandler_RestoreOnFailure(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	ch1 := make(chan *Message, 1)
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.Subscribe([]Topic{{Filter: "a", Handler: func(m *Message) { ch1 <- m }}})
+	}()
+	sub := tc.recv().(*packet.Subscribe)
+	tc.send(&packet.SubACK{
+		PacketID: sub.PacketID,
+		Results:  []packet.SubscribeResult{packet.SubscribeAtMostOnce},
+	})
+	if err := <-ch; err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+
+	// the broker never answers to the second SUBSCRIBE.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.SubscribeContext(ctx, []Topic{
+		{Filter: "a", Handler: func(m *Message) { t.Errorf("unexpected message: %+v", m) }},
+		{Filter: "b", Handler: func(m *Message) { t.Errorf("unexpected message: %+v", m) }},
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("SubscribeContext returns unexpected error: %v", err)
+	}
+	tc.recv()
+
+	// the first handler is kept, and the new one is removed.
+	tc.send(&packet.Publish{TopicName: "a", Payload: []byte("a")})
+	if m := <-ch1; m.Topic != "a" {
+		t.Errorf("unexpected message for a: %+v", m)
+	}
+	tc.send(&packet.Publish{TopicName: "b", Payload: []byte("b")})
+	if m, err := c.Read(true); err != nil || m.Topic != "b" {
+		t.Errorf("unexpected message for default: %+v %v", m, err)
+	}
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+// ErrInflightFull indicates the inflight window is full.
+var ErrInflightFull = errors.New("inflight window is full")
+
+// Token is a future of an asynchronous publish.
+type Token struct {
+	qos  QoS
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newToken(qos QoS) *Token {
+	return &Token{qos: qos, done: make(chan struct{})}
+}
+
+func (t *Token) complete(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+// Done returns a channel which is closed when the publish is completed:
+// acknowledged by the broker for QoS 1 and 2, or sent for QoS 0.
+func (t *Token) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns a result of the publish after Done is closed.
+func (t *Token) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits until the publish is completed or ctx is done.  When ctx is
+// done, the message is kept as in-flight, so it may be delivered after that.
+func (t *Token) Wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return t.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// inflights tracks QoS 1 and 2 publishes which wait acknowledgement, and
+// limits number of them.
+type inflights struct {
+	c      *sync.Cond
+	m      map[packet.ID]*Token
+	max    int
+	fail   bool
+	closed bool
+}
+
+func newInflights(max int, fail bool) *inflights {
+	return &inflights{
+		c:    sync.NewCond(new(sync.Mutex)),
+		m:    map[packet.ID]*Token{},
+		max:  max,
+		fail: fail,
+	}
+}
+
+// add adds a publish to the window.  When the window is full, it waits until
+// a space is available or fails with ErrInflightFull.
+func (w *inflights) add(ctx context.Context, id packet.ID, t *Token) error {
+	stop := context.AfterFunc(ctx, func() {
+		w.c.L.Lock()
+		w.c.Broadcast()
+		w.c.L.Unlock()
+	})
+	defer stop()
+	w.c.L.Lock()
+	defer w.c.L.Unlock()
+	for !w.closed && w.max > 0 && len(w.m) >= w.max {
+		if w.fail {
+			return ErrInflightFull
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		w.c.Wait()
+	}
+	if w.closed {
+		return ErrTerminated
+	}
+	if _, ok := w.m[id]; ok {
+		return fmt.Errorf("duplicated packet ID: %d", id)
+	}
+	w.m[id] = t
+	return nil
+}
+
+// remove removes a publish from the window, and returns its token.
+func (w *inflights) remove(id packet.ID) *Token {
+	w.c.L.Lock()
+	defer w.c.L.Unlock()
+	t, ok := w.m[id]
+	if !ok {
+		return nil
+	}
+	delete(w.m, id)
+	w.c.Broadcast()
+	return t
+}
+
+// ack completes a publish by the last acknowledgement: PUBACK or PUBCOMP.
+func (w *inflights) ack(id packet.ID, p packet.Packet) {
+	t := w.remove(id)
+	if t == nil {
+		return
+	}
+	switch p.(type) {
+	case *packet.PubACK:
+		if t.qos == AtLeastOnce {
+			t.complete(nil)
+			return
+		}
+	case *packet.PubComp:
+		if t.qos == ExactlyOnce {
+			t.complete(nil)
+			return
+		}
+	}
+	t.complete(fmt.Errorf("unexpected response for QoS %d PUBLISH: %T", t.qos, p))
+}
+
+// reject completes a publish with an error.
+func (w *inflights) reject(id packet.ID, err error) {
+	if t := w.remove(id); t != nil {
+		t.complete(err)
+	}
+}
+
+// release completes a publish with an error, only when the publish is still
+// tracked by the token.
+func (w *inflights) release(id packet.ID, t *Token, err error) {
+	w.c.L.Lock()
+	if w.m[id] != t {
+		w.c.L.Unlock()
+		return
+	}
+	delete(w.m, id)
+	w.c.Broadcast()
+	w.c.L.Unlock()
+	t.complete(err)
+}
+
+func (w *inflights) len() int {
+	w.c.L.Lock()
+	defer w.c.L.Unlock()
+	return len(w.m)
+}
+
+// close completes all publishes with an error.
+func (w *inflights) close(err error) {
+	w.c.L.Lock()
+	defer w.c.L.Unlock()
+	for id, t := range w.m {
+		t.complete(err)
+		delete(w.m, id)
+	}
+	w.closed = true
+	w.c.Broadcast()
+}
+
+// PublishAsync publishes a message, and returns a Token to wait its
+// completion.  It blocks only while the inflight window or the offline
+// queue is full.
+func (c *client) PublishAsync(ctx context.Context, qos QoS, retain bool, topic string, msg []byte) *Token {
+	_, t := c.publishAsync(ctx, qos, retain, topic, msg)
+	return t
+}
+
+// publishWait publishes a message with QoS 1 or 2, and waits its completion.
+// When ctx is done, the message is released from the inflight window but
+// kept to be resent, so it may be delivered after that.
+func (c *client) publishWait(ctx context.Context, qos QoS, retain bool, topic string, msg []byte) error {
+	id, t := c.publishAsync(ctx, qos, retain, topic, msg)
+	select {
+	case <-t.done:
+		return t.err
+	case <-ctx.Done():
+		c.inf.release(id, t, ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (c *client) publishAsync(ctx context.Context, qos QoS, retain bool, topic string, msg []byte) (packet.ID, *Token) {
+	t := newToken(qos)
+	if qos == AtMostOnce {
+		t.complete(c.publish0(ctx, retain, topic, msg))
+		return 0, t
+	}
+	if qos != AtLeastOnce && qos != ExactlyOnce {
+		t.complete(errors.New("unsupported QoS"))
+		return 0, t
+	}
+	id := c.emitID()
+	if err := c.inf.add(ctx, id, t); err != nil {
+		t.complete(err)
+		return 0, t
+	}
+	err := c.sendPublish(ctx, &packet.Publish{
+		QoS:       qos.qos(),
+		Retain:    retain,
+		TopicName: topic,
+		PacketID:  id,
+		Payload:   msg,
+	})
+	if err != nil {
+		c.inf.reject(id, err)
+	}
+	return id, t
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestPublishAsync(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			MaxInflight:          2,
+			FailOnInflightFull:   true,
+		},
+	})
+	ctx := context.Background()
+	t1 := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("1"))
+	t2 := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("2"))
+	p1 := tc.recv().(*packet.Publish)
+	tc.recv()
+	if t3 := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("3")); t3.Wait(ctx) != ErrInflightFull {
+		t.Fatalf("unexpected result: %v", t3.Err())
+	}
+	select {
+	case <-t1.Done():
+		t.Fatal("token is completed before PUBACK")
+	default:
+	}
+
+	tc.send(&packet.PubACK{PacketID: p1.PacketID})
+	if err := t1.Wait(ctx); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+	t4 := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("4"))
+	if err := t4.Err(); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+	tc.recv()
+
+	// in-flight publishes are failed by termination.
+	c.Disconnect(true)
+	for _, tk := range []*Token{t2, t4} {
+		if err := tk.Wait(ctx); err != ErrTerminated {
+			t.Errorf("unexpected result: %v", err)
+		}
+	}
+}
+
+func TestPublishAsync_Block(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			MaxInflight:          1,
+		},
+	})
+	ctx := context.Background()
+	t1 := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("1"))
+	p1 := tc.recv().(*packet.Publish)
+	ch := make(chan *Token, 1)
+	go func() {
+		ch <- c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("2"))
+	}()
+	select {
+	case <-ch:
+		t.Fatal("publish should be blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tc.send(&packet.PubACK{PacketID: p1.PacketID})
+	if err := t1.Wait(ctx); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+	t2 := <-ch
+	p2 := tc.recv().(*packet.Publish)
+	tc.send(&packet.PubACK{PacketID: p2.PacketID})
+	if err := t2.Wait(ctx); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+}
+
+func TestPublishCancelReleasesInflight(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			MaxInflight:          1,
+		},
+	})
+	// the broker never answers to the first publish.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Publish1(ctx, false, "a", []byte("1")); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected result: %v", err)
+	}
+	tc.recv()
+	if n := c.Stats().Inflight; n != 0 {
+		t.Fatalf("in-flight publishes are left: %d", n)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.Publish1(context.Background(), false, "a", []byte("2"))
+	}()
+	p2 := tc.recv().(*packet.Publish)
+	tc.send(&packet.PubACK{PacketID: p2.PacketID})
+	if err := <-ch; err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import "github.com/koron/go-mqtt/packet"
+
+// Interceptor inspects, modifies or rejects packets which the client sends
+// and receives.  Its methods are called in the sending or receiving
+// goroutine, so they must not call methods of Client.
+type Interceptor interface {
+	// PreProcess is called after receive a packet and before it is
+	// processed.  It returns the packet to process, which can be modified
+	// one, or nil to ignore the packet.  When it returns an error, the
+	// connection is closed.
+	PreProcess(p packet.Packet) (packet.Packet, error)
+
+	// PreSend is called before send a packet.  It returns the packet to
+	// send, which can be modified one, or nil to drop the packet silently.
+	// When it returns an error, the packet is not sent and the error is
+	// returned to the caller.  Note that a dropped PUBLISH still waits
+	// acknowledgement, and CONNECT can't be dropped.
+	PreSend(p packet.Packet) (packet.Packet, error)
+
+	// PostSend is called after send a packet.
+	PostSend(p packet.Packet)
+}
+
+// NullInterceptor is an Interceptor which does nothing.  It is useful to
+// embed and implement only required methods.
+type NullInterceptor struct{}
+
+var _ Interceptor = NullInterceptor{}
+
+// PreProcess returns the packet as is.
+func (NullInterceptor) PreProcess(p packet.Packet) (packet.Packet, error) {
+	return p, nil
+}
+
+// PreSend returns the packet as is.
+func (NullInterceptor) PreSend(p packet.Packet) (packet.Packet, error) {
+	return p, nil
+}
+
+// PostSend does nothing.
+func (NullInterceptor) PostSend(p packet.Packet) {}
+
+// preProcess applies Interceptors to a received packet in order.
+func (o *Options) preProcess(p packet.Packet) (packet.Packet, error) {
+	for _, ic := range o.Interceptors {
+		var err error
+		p, err = ic.PreProcess(p)
+		if err != nil || p == nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// preSend applies Interceptors to a packet to send in order.  It returns nil
+// when the packet is dropped.
+func (o *Options) preSend(p packet.Packet) (packet.Packet, error) {
+	for _, ic := range o.Interceptors {
+		var err error
+		p, err = ic.PreSend(p)
+		if err != nil || p == nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (o *Options) postSend(p packet.Packet) {
+	for _, ic := range o.Interceptors {
+		ic.PostSend(p)
+	}
+}
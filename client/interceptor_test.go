@@ -0,0 +1,114 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+var errForbidden = errors.New("forbidden")
+
+type testInterceptor struct {
+	NullInterceptor
+	mu   sync.Mutex
+	sent []packet.Packet
+}
+
+func (ic *testInterceptor) PreProcess(p packet.Packet) (packet.Packet, error) {
+	pub, ok := p.(*packet.Publish)
+	if !ok {
+		return p, nil
+	}
+	if pub.TopicName == "drop" {
+		return nil, nil
+	}
+	v := *pub
+	v.Payload = append([]byte("in:"), pub.Payload...)
+	return &v, nil
+}
+
+func (ic *testInterceptor) PreSend(p packet.Packet) (packet.Packet, error) {
+	if _, ok := p.(*packet.PubACK); ok {
+		return nil, nil
+	}
+	pub, ok := p.(*packet.Publish)
+	if !ok {
+		return p, nil
+	}
+	switch pub.TopicName {
+	case "forbidden":
+		return nil, errForbidden
+	case "drop":
+		return nil, nil
+	}
+	v := *pub
+	v.Payload = append([]byte("out:"), pub.Payload...)
+	return &v, nil
+}
+
+func (ic *testInterceptor) PostSend(p packet.Packet) {
+	ic.mu.Lock()
+	ic.sent = append(ic.sent, p)
+	ic.mu.Unlock()
+}
+
+func TestInterceptor(t *testing.T) {
+	ic := &testInterceptor{}
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			Interceptors:         []Interceptor{ic, NullInterceptor{}},
+		},
+	})
+
+	// modify outgoing packets.
+	if err := c.Publish(AtMostOnce, false, "a", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	if p := tc.recv().(*packet.Publish); string(p.Payload) != "out:hello" {
+		t.Errorf("unexpected payload: %q", p.Payload)
+	}
+	// reject outgoing packets.
+	if err := c.Publish(AtMostOnce, false, "forbidden", []byte("x")); err != errForbidden {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// drop outgoing packets silently.
+	if err := c.Publish(AtMostOnce, false, "drop", []byte("x")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// ignore and modify incoming packets.
+	tc.send(&packet.Publish{TopicName: "drop", Payload: []byte("x")})
+	tc.send(&packet.Publish{TopicName: "b", Payload: []byte("world")})
+	m, err := c.Read(true)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if m.Topic != "b" || string(m.Body) != "in:world" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+
+	// drop PUBACK in receiving goroutine.
+	tc.send(&packet.Publish{QoS: packet.QAtLeastOnce, PacketID: 1, TopicName: "c", Payload: []byte("qos1")})
+	if m, err := c.Read(true); err != nil || m.Topic != "c" {
+		t.Fatalf("unexpected message: %+v %v", m, err)
+	}
+	if err := c.Publish(AtMostOnce, false, "a", []byte("again")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	if p, ok := tc.recv().(*packet.Publish); !ok || string(p.Payload) != "out:again" {
+		t.Errorf("unexpected packet: %+v", p)
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if len(ic.sent) != 3 {
+		t.Fatalf("unexpected sent packets: %+v", ic.sent)
+	}
+	if _, ok := ic.sent[0].(*packet.Connect); !ok {
+		t.Errorf("first packet should be CONNECT: %+v", ic.sent[0])
+	}
+}
@@ -1,7 +1,43 @@
 package client
 
+import (
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
 // Message represents a MQTT's published message.
 type Message struct {
 	Topic string
 	Body  []byte
+
+	// QoS is QoS level of delivery, which is the lower of publisher's and
+	// subscription's.
+	QoS QoS
+
+	// Retain is true when the message is a retained message, which is sent
+	// by the broker on subscribe.
+	Retain bool
+
+	// Dup is true when the message may be re-delivery.
+	Dup bool
+
+	// PacketID is packet identifier of the PUBLISH packet.  It is zero for
+	// QoS 0.
+	PacketID packet.ID
+
+	// ReceivedAt is the time when the message was received.
+	ReceivedAt time.Time
+}
+
+func toMessage(p *packet.Publish) *Message {
+	return &Message{
+		Topic:      p.TopicName,
+		Body:       p.Payload,
+		QoS:        publishQoS(p.QoS),
+		Retain:     p.Retain,
+		Dup:        p.Dup,
+		PacketID:   p.PacketID,
+		ReceivedAt: time.Now(),
+	}
 }
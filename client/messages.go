@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"iter"
+)
+
+// Messages returns a channel which receives messages.  A message which was
+// taken from the buffer when ctx is done, may be lost.
+func (c *client) Messages(ctx context.Context) <-chan *Message {
+	ch := make(chan *Message)
+	go func() {
+		defer close(ch)
+		for {
+			m, err := c.read(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// MessageSeq returns an iterator over messages.
+func (c *client) MessageSeq(ctx context.Context) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for {
+			m, err := c.read(ctx)
+			if err != nil {
+				if err == ErrTerminated {
+					err = c.Err()
+				}
+				yield(nil, err)
+				return
+			}
+			if !yield(m, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the disconnect reason.
+func (c *client) Err() error {
+	c.sl.Lock()
+	defer c.sl.Unlock()
+	if !c.term {
+		return nil
+	}
+	return c.derr
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestMessages(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	ch := c.Messages(context.Background())
+	tc.send(&packet.Publish{TopicName: "a", Payload: []byte("a")})
+	if m := <-ch; m == nil || m.Topic != "a" {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+	tc.conn.Close()
+	if m, ok := <-ch; ok {
+		t.Fatalf("channel should be closed: %+v", m)
+	}
+	if err := c.Err(); err != io.EOF {
+		t.Errorf("unexpected reason: %v", err)
+	}
+}
+
+func TestMessages_Cancel(t *testing.T) {
+	c, _ := connectTestBroker(t, Param{})
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Messages(ctx)
+	cancel()
+	if m, ok := <-ch; ok {
+		t.Fatalf("channel should be closed: %+v", m)
+	}
+	if err := c.Err(); err != nil {
+		t.Errorf("client should be connected: %v", err)
+	}
+}
+
+func TestMessageSeq(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{})
+	for _, topic := range []string{"a", "b"} {
+		tc.send(&packet.Publish{TopicName: topic, Payload: []byte(topic)})
+	}
+	var topics []string
+	var last error
+	for m, err := range c.MessageSeq(context.Background()) {
+		if err != nil {
+			last = err
+			break
+		}
+		topics = append(topics, m.Topic)
+		if len(topics) == 2 {
+			c.Disconnect(true)
+		}
+	}
+	if len(topics) != 2 || topics[0] != "a" || topics[1] != "b" {
+		t.Errorf("unexpected messages: %v", topics)
+	}
+	if last != Explicitly {
+		t.Errorf("unexpected reason: %v", last)
+	}
+}
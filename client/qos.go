@@ -58,3 +58,14 @@ func toQoS(r packet.SubscribeResult) QoS {
 		return Failure
 	}
 }
+
+func publishQoS(q packet.QoS) QoS {
+	switch q {
+	case packet.QAtLeastOnce:
+		return AtLeastOnce
+	case packet.QExactlyOnce:
+		return ExactlyOnce
+	default:
+		return AtMostOnce
+	}
+}
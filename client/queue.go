@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+var (
+	// ErrQueueFull indicates the offline queue is full.
+	ErrQueueFull = errors.New("offline queue is full")
+
+	// ErrExpired indicates a queued message is expired before sent.
+	ErrExpired = errors.New("queued message expired")
+
+	// errNotQueued indicates a message should be sent immediately.
+	errNotQueued = errors.New("not queued")
+)
+
+// queuedPublish is a PUBLISH packet in the offline queue.
+type queuedPublish struct {
+	p  *packet.Publish
+	at time.Time
+}
+
+// queue is the offline queue, which holds PUBLISH packets while
+// disconnected.
+type queue struct {
+	c      *sync.Cond
+	items  []*queuedPublish
+	size   int
+	policy OverflowPolicy
+	expiry time.Duration
+	online bool
+	closed bool
+}
+
+func newQueue(size int, policy OverflowPolicy, expiry time.Duration) *queue {
+	return &queue{
+		c:      sync.NewCond(new(sync.Mutex)),
+		size:   size,
+		policy: policy,
+		expiry: expiry,
+		online: true,
+	}
+}
+
+// push puts a packet into the queue when offline or other packets are
+// queued.  Otherwise it returns errNotQueued.  Expired packets and dropped
+// packets by the overflow policy are returned.
+func (q *queue) push(ctx context.Context, p *packet.Publish) (expired, dropped []*packet.Publish, err error) {
+	stop := context.AfterFunc(ctx, func() {
+		q.c.L.Lock()
+		q.c.Broadcast()
+		q.c.L.Unlock()
+	})
+	defer stop()
+	q.c.L.Lock()
+	defer q.c.L.Unlock()
+	if q.closed {
+		return nil, nil, ErrTerminated
+	}
+	if q.online && len(q.items) == 0 {
+		return nil, nil, errNotQueued
+	}
+	expired = q.purge(time.Now())
+	for len(q.items) >= q.size {
+		switch q.policy {
+		case DropOldest:
+			dropped = append(dropped, q.items[0].p)
+			q.items[0] = nil
+			q.items = q.items[1:]
+			continue
+		case DropNewest:
+			return expired, dropped, ErrQueueFull
+		}
+		// Block
+		if err := ctx.Err(); err != nil {
+			return expired, dropped, err
+		}
+		q.c.Wait()
+		if q.closed {
+			return expired, dropped, ErrTerminated
+		}
+		if q.online && len(q.items) == 0 {
+			return expired, dropped, errNotQueued
+		}
+	}
+	q.items = append(q.items, &queuedPublish{p: p, at: time.Now()})
+	return expired, dropped, nil
+}
+
+// purge removes expired packets.
+func (q *queue) purge(now time.Time) []*packet.Publish {
+	if q.expiry <= 0 {
+		return nil
+	}
+	var expired []*packet.Publish
+	for len(q.items) > 0 && now.Sub(q.items[0].at) > q.expiry {
+		expired = append(expired, q.items[0].p)
+		q.items[0] = nil
+		q.items = q.items[1:]
+	}
+	return expired
+}
+
+// pop takes the first packet from the queue.  When the queue is empty, it
+// returns nil and the queue becomes online.
+func (q *queue) pop() (*packet.Publish, []*packet.Publish) {
+	q.c.L.Lock()
+	defer q.c.L.Unlock()
+	expired := q.purge(time.Now())
+	if len(q.items) == 0 {
+		q.online = true
+		q.c.Broadcast()
+		return nil, expired
+	}
+	p := q.items[0].p
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.c.Broadcast()
+	return p, expired
+}
+
+// unshift puts back a packet to the head of the queue.
+func (q *queue) unshift(p *packet.Publish) {
+	q.c.L.Lock()
+	q.items = append([]*queuedPublish{{p: p, at: time.Now()}}, q.items...)
+	q.c.L.Unlock()
+}
+
+// offline marks the queue to accept packets.
+func (q *queue) offline() {
+	q.c.L.Lock()
+	q.online = false
+	q.c.L.Unlock()
+}
+
+func (q *queue) isOffline() bool {
+	q.c.L.Lock()
+	defer q.c.L.Unlock()
+	return !q.online
+}
+
+// ids returns packet IDs of queued QoS 1 and 2 packets.
+func (q *queue) ids() []packet.ID {
+	q.c.L.Lock()
+	defer q.c.L.Unlock()
+	var ids []packet.ID
+	for _, item := range q.items {
+		if item.p.QoS != packet.QAtMostOnce {
+			ids = append(ids, item.p.PacketID)
+		}
+	}
+	return ids
+}
+
+// close discards all packets, and returns them.
+func (q *queue) close() []*packet.Publish {
+	q.c.L.Lock()
+	defer q.c.L.Unlock()
+	q.closed = true
+	discarded := make([]*packet.Publish, len(q.items))
+	for i, item := range q.items {
+		discarded[i] = item.p
+	}
+	q.items = nil
+	q.c.Broadcast()
+	return discarded
+}
+
+// sendPublish sends a PUBLISH packet.  While disconnected, the packet is
+// queued into the offline queue if it is enabled.
+func (c *client) sendPublish(ctx context.Context, p *packet.Publish) error {
+	if c.q != nil {
+		err := c.enqueue(ctx, p)
+		if err != errNotQueued {
+			return err
+		}
+	}
+	err := c.sendPublishNow(p)
+	if err != nil && c.q != nil && c.q.isOffline() {
+		// the connection was lost while sending.
+		return c.enqueue(ctx, p)
+	}
+	return err
+}
+
+func (c *client) sendPublishNow(p *packet.Publish) error {
+	if p.QoS != packet.QAtMostOnce {
+		if err := c.st.PutOutgoing(p.PacketID, p); err != nil {
+			return err
+		}
+		c.markSent(p.PacketID, p)
+	}
+	if err := c.send(p); err != nil {
+		if p.QoS != packet.QAtMostOnce {
+			c.forget(p.PacketID)
+			c.st.DeleteOutgoing(p.PacketID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *client) enqueue(ctx context.Context, p *packet.Publish) error {
+	expired, dropped, err := c.q.push(ctx, p)
+	c.discardQueued(expired, ErrExpired)
+	c.discardQueued(dropped, ErrQueueFull)
+	return err
+}
+
+// flush sends all queued packets in order.
+func (c *client) flush() {
+	if c.q == nil {
+		return
+	}
+	for {
+		p, expired := c.q.pop()
+		c.discardQueued(expired, ErrExpired)
+		if p == nil {
+			return
+		}
+		if err := c.sendPublishNow(p); err != nil {
+			if c.q.isOffline() {
+				// the connection was lost again, retry at next connection.
+				c.q.unshift(p)
+				return
+			}
+			c.logResendError(p, err)
+			if p.QoS != packet.QAtMostOnce {
+				c.inf.reject(p.PacketID, err)
+			}
+		}
+	}
+}
+
+// discardQueued notifies discarded packets to waiting callers.
+func (c *client) discardQueued(packets []*packet.Publish, reason error) {
+	for _, p := range packets {
+		c.logDroppedPublish(p, reason)
+		if p.QoS != packet.QAtMostOnce {
+			c.inf.reject(p.PacketID, reason)
+		}
+	}
+}
+
+func (c *client) logDroppedPublish(p *packet.Publish, reason error) {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("dropped publish: topic=%s: %v", p.TopicName, reason)
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestOfflineQueue(t *testing.T) {
+	states := make(chan State, 10)
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			AutoReconnect:        true,
+			ReconnectMinDelay:    10 * time.Millisecond,
+			OfflineQueueSize:     3,
+			OfflineQueuePolicy:   DropNewest,
+		},
+		OnStateChange: func(s State, err error) {
+			states <- s
+		},
+	})
+	tc.conn.Close()
+	if s := <-states; s != Reconnecting {
+		t.Fatalf("unexpected state: %s", s)
+	}
+
+	for _, s := range []string{"1", "2"} {
+		if err := c.Publish(AtMostOnce, false, "a/b", []byte(s)); err != nil {
+			t.Fatalf("Publish failed: %s", err)
+		}
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.Publish(AtLeastOnce, false, "a/b", []byte("3"))
+	}()
+	for len(c.q.ids()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Publish(AtMostOnce, false, "a/b", []byte("4")); err != ErrQueueFull {
+		t.Fatalf("unexpected error for full queue: %v", err)
+	}
+
+	tc = tc.b.accept(nil)
+	if s := <-states; s != Connected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	for _, s := range []string{"1", "2"} {
+		p, ok := tc.recv().(*packet.Publish)
+		if !ok || string(p.Payload) != s {
+			t.Fatalf("unexpected PUBLISH: %+v", p)
+		}
+	}
+	p, ok := tc.recv().(*packet.Publish)
+	if !ok || string(p.Payload) != "3" || p.QoS != packet.QAtLeastOnce {
+		t.Fatalf("unexpected PUBLISH: %+v", p)
+	}
+	tc.send(&packet.PubACK{PacketID: p.PacketID})
+	if err := <-ch; err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+}
+
+func TestOfflineQueue_Expiry(t *testing.T) {
+	q := newQueue(10, Block, 10*time.Millisecond)
+	q.offline()
+	for _, s := range []string{"1", "2"} {
+		_, _, err := q.push(t.Context(), &packet.Publish{Payload: []byte(s)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	p, expired := q.pop()
+	if p != nil {
+		t.Errorf("expired packet is popped: %+v", p)
+	}
+	if len(expired) != 2 {
+		t.Errorf("unexpected expired packets: %+v", expired)
+	}
+	if q.isOffline() {
+		t.Error("queue should be online after flushed")
+	}
+}
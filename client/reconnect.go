@@ -0,0 +1,191 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func (c *client) addSubscriptions(topics []Topic, results []QoS) {
+	c.tl.Lock()
+	for i, t := range topics {
+		if i >= len(results) || results[i] == Failure {
+			continue
+		}
+		c.tm[t.Filter] = t
+	}
+	c.tl.Unlock()
+}
+
+func (c *client) removeSubscriptions(filters []string) {
+	c.tl.Lock()
+	for _, f := range filters {
+		delete(c.tm, f)
+	}
+	c.tl.Unlock()
+}
+
+// subscriptions returns current subscriptions, sorted by filter.
+func (c *client) subscriptions() []Topic {
+	c.tl.Lock()
+	topics := make([]Topic, 0, len(c.tm))
+	for _, t := range c.tm {
+		topics = append(topics, t)
+	}
+	c.tl.Unlock()
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Filter < topics[j].Filter
+	})
+	return topics
+}
+
+// drop closes current connection to reconnect, when AutoReconnect is enabled
+// and the client is not terminated yet.
+func (c *client) drop(quit chan bool) bool {
+	c.sl.Lock()
+	defer c.sl.Unlock()
+	if c.term || !c.p.options().AutoReconnect || c.quit != quit {
+		return false
+	}
+	if c.q != nil {
+		c.q.offline()
+	}
+	close(c.quit)
+	c.conn.Close()
+	c.conn = nil
+	c.stats.connectedAt.Store(0)
+	c.ping.Close()
+	// in-flight publishes are kept, to be resent after reconnected.
+	c.closeAllWaitOps()
+	return true
+}
+
+// reconnect tries to connect to the broker again until succeeded or the
+// client is terminated.
+func (c *client) reconnect(reason error) bool {
+	c.logReconnect(reason)
+	c.emitStateChange(Reconnecting, reason)
+	delay := c.p.options().reconnectBackoff()
+	ti := time.NewTimer(delay.Next())
+	defer ti.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-ti.C:
+		}
+		l, err := connect(c.ctx, c.p, c.Addr())
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return false
+			}
+			c.logReconnect(err)
+			c.emitStateChange(Reconnecting, err)
+			ti.Reset(delay.Next())
+			continue
+		}
+		if !c.resume(l) {
+			l.conn.Close()
+			return false
+		}
+		c.logConnected(l.addr)
+		c.countReconnect()
+		c.emitStateChange(Connected, nil)
+		c.resend()
+		c.flush()
+		if !l.ack.SessionPresent {
+			c.resubscribe()
+		}
+		return true
+	}
+}
+
+// resume starts to use a new connection.
+func (c *client) resume(l *link) bool {
+	c.sl.Lock()
+	defer c.sl.Unlock()
+	if c.term {
+		return false
+	}
+	if !l.ack.SessionPresent {
+		// the broker lost the session, so PUBREL won't come.
+		if err := c.st.ClearIncoming(); err != nil {
+			c.logStoreError(err)
+		}
+	}
+	c.conn = l.conn
+	c.addr = l.addr
+	c.r = l.r
+	c.quit = make(chan bool, 1)
+	c.run()
+	return true
+}
+
+// resend resends unacknowledged PUBLISH packets with DUP flag, and PUBREL
+// packets.
+func (c *client) resend() {
+	packets, err := c.st.Outgoings()
+	if err != nil {
+		c.logStoreError(err)
+		return
+	}
+	for _, p := range packets {
+		if err := c.retry(p); err != nil {
+			c.logResendError(p, err)
+			return
+		}
+	}
+}
+
+// resubscribe restores subscriptions for a new session.
+func (c *client) resubscribe() {
+	topics := c.subscriptions()
+	if len(topics) == 0 {
+		return
+	}
+	if err := c.SubscribeContext(c.ctx, topics); err != nil {
+		c.logResubscribeError(err)
+	}
+}
+
+func (c *client) emitStateChange(s State, err error) {
+	if c.p.OnStateChange != nil {
+		c.p.OnStateChange(s, err)
+	}
+}
+
+func (c *client) logReconnect(reason error) {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("reconnecting: %v", reason)
+}
+
+func (c *client) logConnected(addr string) {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("connected to %s", addr)
+}
+
+func (c *client) logResendError(p packet.Packet, err error) {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("failed to resend packet %#v: %v", p, err)
+}
+
+func (c *client) logStoreError(err error) {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("store error: %v", err)
+}
+
+func (c *client) logResubscribeError(err error) {
+	if c.log == nil {
+		return
+	}
+	c.log.Printf("failed to resubscribe: %v", err)
+}
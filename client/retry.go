@@ -0,0 +1,150 @@
+package client
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+// ErrRetryExhausted indicates a message is not acknowledged after maximum
+// number of sending attempts.
+var ErrRetryExhausted = errors.New("retry exhausted")
+
+// retryEntry is a sent PUBLISH or PUBREL packet which waits acknowledgement.
+type retryEntry struct {
+	p   packet.Packet
+	seq uint64    // order of first sending
+	at  time.Time // last time when the packet was sent
+	n   int       // number of sending attempts
+}
+
+// markSent records a packet to be sent at first.
+func (c *client) markSent(id packet.ID, p packet.Packet) {
+	c.rl.Lock()
+	c.rs++
+	c.rm[id] = &retryEntry{p: p, seq: c.rs, at: time.Now(), n: 1}
+	c.rl.Unlock()
+}
+
+// markResent records a packet to be resent.  It returns false when sending
+// attempts of the packet reached the limit.
+func (c *client) markResent(id packet.ID, p packet.Packet) bool {
+	c.rl.Lock()
+	defer c.rl.Unlock()
+	e, ok := c.rm[id]
+	if !ok {
+		// restored from the Store.
+		c.rs++
+		c.rm[id] = &retryEntry{p: p, seq: c.rs, at: time.Now(), n: 1}
+		return true
+	}
+	if !c.canRetry(e) {
+		return false
+	}
+	e.p = p
+	e.at = time.Now()
+	e.n++
+	return true
+}
+
+func (c *client) canRetry(e *retryEntry) bool {
+	max := c.p.options().RetryMaxAttempts
+	return max <= 0 || e.n < max
+}
+
+// forget removes a packet which was acknowledged or discarded.
+func (c *client) forget(id packet.ID) {
+	c.rl.Lock()
+	delete(c.rm, id)
+	c.rl.Unlock()
+}
+
+// due returns packets which are not acknowledged in d to resend in order,
+// and IDs of packets which reached the limit of sending attempts.
+func (c *client) due(d time.Duration) ([]packet.Packet, []packet.ID) {
+	c.rl.Lock()
+	defer c.rl.Unlock()
+	var (
+		entries []*retryEntry
+		ids     []packet.ID
+	)
+	now := time.Now()
+	for id, e := range c.rm {
+		if now.Sub(e.at) < d {
+			continue
+		}
+		if !c.canRetry(e) {
+			ids = append(ids, id)
+			continue
+		}
+		e.at = now
+		e.n++
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	packets := make([]packet.Packet, len(entries))
+	for i, e := range entries {
+		packets[i] = e.p
+	}
+	return packets, ids
+}
+
+// retryLoop resends packets which are not acknowledged in RetryInterval.
+func (c *client) retryLoop(quit chan bool) {
+	d := c.p.options().RetryInterval
+	tc := time.NewTicker(max(d/2, time.Millisecond))
+	defer tc.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-tc.C:
+			packets, ids := c.due(d)
+			for _, id := range ids {
+				c.giveUp(id)
+			}
+			for _, p := range packets {
+				if err := c.send(dupPacket(p)); err != nil {
+					c.logResendError(p, err)
+					break
+				}
+			}
+		}
+	}
+}
+
+// retry resends a packet with DUP flag, or gives up it when sending attempts
+// reached the limit.
+func (c *client) retry(p packet.Packet) error {
+	id := packetID(p)
+	if !c.markResent(id, p) {
+		c.giveUp(id)
+		return nil
+	}
+	return c.send(dupPacket(p))
+}
+
+// giveUp discards an in-flight packet, and notifies a failure to the caller.
+func (c *client) giveUp(id packet.ID) {
+	c.forget(id)
+	if err := c.st.DeleteOutgoing(id); err != nil {
+		c.logStoreError(err)
+	}
+	c.inf.reject(id, ErrRetryExhausted)
+}
+
+// dupPacket returns a copy of PUBLISH packet with DUP flag.  Other packets
+// are returned as is.
+func dupPacket(p packet.Packet) packet.Packet {
+	pub, ok := p.(*packet.Publish)
+	if !ok {
+		return p
+	}
+	dup := *pub
+	dup.Dup = true
+	return &dup
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+func TestRetry(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			RetryInterval:        50 * time.Millisecond,
+			RetryMaxAttempts:     3,
+		},
+	})
+	ctx := context.Background()
+	tk := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("hello"))
+	for i := 0; i < 3; i++ {
+		p := tc.recv().(*packet.Publish)
+		if p.Dup != (i > 0) {
+			t.Fatalf("unexpected DUP flag at #%d: %+v", i, p)
+		}
+	}
+	if err := tk.Wait(ctx); err != ErrRetryExhausted {
+		t.Fatalf("unexpected result: %v", err)
+	}
+	if packets, _ := c.st.Outgoings(); len(packets) != 0 {
+		t.Fatalf("given up packets remain in the store: %+v", packets)
+	}
+}
+
+func TestRetry_PubRel(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			RetryInterval:        50 * time.Millisecond,
+		},
+	})
+	ctx := context.Background()
+	tk := c.PublishAsync(ctx, ExactlyOnce, false, "a", []byte("hello"))
+	p := tc.recv().(*packet.Publish)
+	tc.send(&packet.PubRec{PacketID: p.PacketID})
+	for i := 0; i < 2; i++ {
+		if rel, ok := tc.recv().(*packet.PubRel); !ok || rel.PacketID != p.PacketID {
+			t.Fatalf("unexpected PUBREL at #%d: %+v", i, rel)
+		}
+	}
+	tc.send(&packet.PubComp{PacketID: p.PacketID})
+	if err := tk.Wait(ctx); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+}
+
+func TestRetry_ShortInterval(t *testing.T) {
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			RetryInterval:        time.Nanosecond,
+			RetryMaxAttempts:     2,
+		},
+	})
+	ctx := context.Background()
+	tk := c.PublishAsync(ctx, AtLeastOnce, false, "a", []byte("hello"))
+	tc.recv()
+	if p := tc.recv().(*packet.Publish); !p.Dup {
+		t.Fatalf("unexpected PUBLISH: %+v", p)
+	}
+	if err := tk.Wait(ctx); err != ErrRetryExhausted {
+		t.Fatalf("unexpected result: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+// PacketStats is number of packets and bytes.
+type PacketStats struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// Stats is a snapshot of statistics of the client.
+type Stats struct {
+	// Sent and Received are packets which sent and received after
+	// connected, for each packet type.
+	Sent     map[packet.Type]PacketStats
+	Received map[packet.Type]PacketStats
+
+	// Dropped is number of messages dropped from the buffer for Read.
+	Dropped uint64
+
+	// Inflight is number of QoS 1 and 2 publishes which wait
+	// acknowledgements.
+	Inflight int
+
+	// Reconnects is number of succeeded reconnections.
+	Reconnects uint64
+
+	// PingRTT is round trip time of the last PINGREQ and PINGRESP.
+	PingRTT time.Duration
+
+	// Uptime is elapsed time since the current connection is established.
+	// It is zero while disconnected.
+	Uptime time.Duration
+}
+
+// Metrics receives events of the client to export metrics.  Its methods
+// are called synchronously, so they should return quickly.
+type Metrics interface {
+	// PacketSent is called after a packet is sent.
+	PacketSent(t packet.Type, bytes int)
+
+	// PacketReceived is called after a packet is received.
+	PacketReceived(t packet.Type, bytes int)
+
+	// MessageDropped is called when a message is dropped from the buffer
+	// for Read.
+	MessageDropped()
+
+	// Reconnected is called when reconnection is succeeded.
+	Reconnected()
+
+	// PingRTT is called with round trip time of PINGREQ and PINGRESP.
+	PingRTT(d time.Duration)
+}
+
+// counter counts packets and bytes for each packet type.
+type counter struct {
+	packets [16]atomic.Uint64
+	bytes   [16]atomic.Uint64
+}
+
+func (c *counter) add(t packet.Type, n int) {
+	c.packets[t&0x0f].Add(1)
+	c.bytes[t&0x0f].Add(uint64(n))
+}
+
+func (c *counter) snapshot() map[packet.Type]PacketStats {
+	m := map[packet.Type]PacketStats{}
+	for i := range c.packets {
+		n := c.packets[i].Load()
+		if n == 0 {
+			continue
+		}
+		m[packet.Type(i)] = PacketStats{Packets: n, Bytes: c.bytes[i].Load()}
+	}
+	return m
+}
+
+// stats holds statistics of the client.
+type stats struct {
+	sent        counter
+	received    counter
+	dropped     atomic.Uint64
+	reconnects  atomic.Uint64
+	rtt         atomic.Int64
+	connectedAt atomic.Int64 // zero while disconnected
+}
+
+func (c *client) Stats() Stats {
+	s := Stats{
+		Sent:       c.stats.sent.snapshot(),
+		Received:   c.stats.received.snapshot(),
+		Dropped:    c.stats.dropped.Load(),
+		Inflight:   c.inf.len(),
+		Reconnects: c.stats.reconnects.Load(),
+		PingRTT:    time.Duration(c.stats.rtt.Load()),
+	}
+	if at := c.stats.connectedAt.Load(); at != 0 {
+		s.Uptime = time.Since(time.Unix(0, at))
+	}
+	return s
+}
+
+func (c *client) countSent(b []byte) {
+	t := packet.Type(b[0] >> 4)
+	c.stats.sent.add(t, len(b))
+	if m := c.p.options().Metrics; m != nil {
+		m.PacketSent(t, len(b))
+	}
+}
+
+func (c *client) countReceived(b []byte) {
+	t := packet.Type(b[0] >> 4)
+	c.stats.received.add(t, len(b))
+	if m := c.p.options().Metrics; m != nil {
+		m.PacketReceived(t, len(b))
+	}
+}
+
+func (c *client) countDropped() {
+	c.stats.dropped.Add(1)
+	if m := c.p.options().Metrics; m != nil {
+		m.MessageDropped()
+	}
+}
+
+func (c *client) countReconnect() {
+	c.stats.reconnects.Add(1)
+	if m := c.p.options().Metrics; m != nil {
+		m.Reconnected()
+	}
+}
+
+func (c *client) updatePingRTT(d time.Duration) {
+	c.stats.rtt.Store(int64(d))
+	if m := c.p.options().Metrics; m != nil {
+		m.PingRTT(d)
+	}
+}
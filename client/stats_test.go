@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+type testMetrics struct {
+	mu       sync.Mutex
+	sent     map[packet.Type]int
+	received map[packet.Type]int
+	dropped  int
+	rtt      time.Duration
+}
+
+func (m *testMetrics) PacketSent(t packet.Type, bytes int) {
+	m.mu.Lock()
+	m.sent[t] += bytes
+	m.mu.Unlock()
+}
+
+func (m *testMetrics) PacketReceived(t packet.Type, bytes int) {
+	m.mu.Lock()
+	m.received[t] += bytes
+	m.mu.Unlock()
+}
+
+func (m *testMetrics) MessageDropped() {
+	m.mu.Lock()
+	m.dropped++
+	m.mu.Unlock()
+}
+
+func (m *testMetrics) Reconnected() {}
+
+func (m *testMetrics) PingRTT(d time.Duration) {
+	m.mu.Lock()
+	m.rtt = d
+	m.mu.Unlock()
+}
+
+func TestStats(t *testing.T) {
+	mt := &testMetrics{
+		sent:     map[packet.Type]int{},
+		received: map[packet.Type]int{},
+	}
+	c, tc := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			ReadBufferSize:       1,
+			ReadBufferPolicy:     DropOldest,
+			Metrics:              mt,
+		},
+	})
+
+	// publish a message and wait its PUBACK.
+	tk := c.PublishAsync(context.Background(), AtLeastOnce, false, "a", []byte("hello"))
+	p := tc.recv().(*packet.Publish)
+	if n := c.Stats().Inflight; n != 1 {
+		t.Errorf("unexpected inflight: %d", n)
+	}
+	tc.send(&packet.PubACK{PacketID: p.PacketID})
+	if err := tk.Wait(context.Background()); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+
+	// receive 2 messages, then one of them is dropped.
+	tc.send(&packet.Publish{TopicName: "b", Payload: []byte("1")})
+	tc.send(&packet.Publish{TopicName: "b", Payload: []byte("2")})
+
+	// ping to measure RTT.
+	errc := make(chan error, 1)
+	go func() { errc <- c.Ping() }()
+	if _, ok := tc.recv().(*packet.PingReq); !ok {
+		t.Fatal("PINGREQ expected")
+	}
+	time.Sleep(10 * time.Millisecond)
+	tc.send(&packet.PingResp{})
+	if err := <-errc; err != nil {
+		t.Fatalf("Ping failed: %s", err)
+	}
+
+	st := c.Stats()
+	pub, _ := p.Encode()
+	if s := st.Sent[packet.TPublish]; s.Packets != 1 || s.Bytes != uint64(len(pub)) {
+		t.Errorf("unexpected sent PUBLISH: %+v", s)
+	}
+	if s := st.Sent[packet.TPingReq]; s.Packets != 1 || s.Bytes != 2 {
+		t.Errorf("unexpected sent PINGREQ: %+v", s)
+	}
+	if s := st.Received[packet.TPublish]; s.Packets != 2 {
+		t.Errorf("unexpected received PUBLISH: %+v", s)
+	}
+	if s := st.Received[packet.TPubACK]; s.Packets != 1 || s.Bytes != 4 {
+		t.Errorf("unexpected received PUBACK: %+v", s)
+	}
+	if st.Dropped != 1 {
+		t.Errorf("unexpected dropped: %d", st.Dropped)
+	}
+	if st.Inflight != 0 {
+		t.Errorf("unexpected inflight: %d", st.Inflight)
+	}
+	if st.PingRTT < 10*time.Millisecond {
+		t.Errorf("too short ping RTT: %s", st.PingRTT)
+	}
+	if st.Uptime <= 0 {
+		t.Errorf("unexpected uptime: %s", st.Uptime)
+	}
+
+	mt.mu.Lock()
+	if mt.sent[packet.TPublish] != len(pub) || mt.received[packet.TPubACK] != 4 || mt.dropped != 1 || mt.rtt != st.PingRTT {
+		t.Errorf("unexpected metrics: %+v", mt)
+	}
+	mt.mu.Unlock()
+
+	c.Disconnect(false)
+	if d := c.Stats().Uptime; d != 0 {
+		t.Errorf("uptime should be zero after closed: %s", d)
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+// Store stores session state of the client: outgoing in-flight packets and
+// packet IDs of incoming QoS 2 messages.
+type Store interface {
+	// PutOutgoing stores an outgoing PUBLISH or PUBREL packet, which waiting
+	// acknowledgement.  When a packet with same ID exists, it is replaced
+	// with keeping its order.
+	PutOutgoing(id packet.ID, p packet.Packet) error
+
+	// DeleteOutgoing removes an outgoing packet.
+	DeleteOutgoing(id packet.ID) error
+
+	// Outgoings returns all outgoing packets in stored order.
+	Outgoings() ([]packet.Packet, error)
+
+	// PutIncoming records a packet ID of received QoS 2 message, which
+	// waiting PUBREL.
+	PutIncoming(id packet.ID) error
+
+	// DeleteIncoming removes a packet ID of received QoS 2 message.
+	DeleteIncoming(id packet.ID) error
+
+	// HasIncoming checks a packet ID of received QoS 2 message is recorded.
+	HasIncoming(id packet.ID) (bool, error)
+
+	// ClearIncoming removes all packet IDs of received QoS 2 messages.
+	ClearIncoming() error
+
+	// Reset removes all stored packets and packet IDs.
+	Reset() error
+}
+
+// MemoryStore is a Store which holds state in memory.
+type MemoryStore struct {
+	mu  sync.Mutex
+	seq uint64
+	out map[packet.ID]*storedPacket
+	in  map[packet.ID]struct{}
+}
+
+type storedPacket struct {
+	seq uint64
+	p   packet.Packet
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		out: map[packet.ID]*storedPacket{},
+		in:  map[packet.ID]struct{}{},
+	}
+}
+
+// PutOutgoing stores an outgoing packet.
+func (s *MemoryStore) PutOutgoing(id packet.ID, p packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sp, ok := s.out[id]; ok {
+		sp.p = p
+		return nil
+	}
+	s.seq++
+	s.out[id] = &storedPacket{seq: s.seq, p: p}
+	return nil
+}
+
+// DeleteOutgoing removes an outgoing packet.
+func (s *MemoryStore) DeleteOutgoing(id packet.ID) error {
+	s.mu.Lock()
+	delete(s.out, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Outgoings returns all outgoing packets in stored order.
+func (s *MemoryStore) Outgoings() ([]packet.Packet, error) {
+	s.mu.Lock()
+	list := make([]*storedPacket, 0, len(s.out))
+	for _, sp := range s.out {
+		list = append(list, sp)
+	}
+	s.mu.Unlock()
+	return sortStoredPackets(list), nil
+}
+
+// PutIncoming records a packet ID of received QoS 2 message.
+func (s *MemoryStore) PutIncoming(id packet.ID) error {
+	s.mu.Lock()
+	s.in[id] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+// DeleteIncoming removes a packet ID of received QoS 2 message.
+func (s *MemoryStore) DeleteIncoming(id packet.ID) error {
+	s.mu.Lock()
+	delete(s.in, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// HasIncoming checks a packet ID of received QoS 2 message is recorded.
+func (s *MemoryStore) HasIncoming(id packet.ID) (bool, error) {
+	s.mu.Lock()
+	_, ok := s.in[id]
+	s.mu.Unlock()
+	return ok, nil
+}
+
+// ClearIncoming removes all packet IDs of received QoS 2 messages.
+func (s *MemoryStore) ClearIncoming() error {
+	s.mu.Lock()
+	clear(s.in)
+	s.mu.Unlock()
+	return nil
+}
+
+// Reset removes all stored packets and packet IDs.
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	clear(s.out)
+	clear(s.in)
+	s.mu.Unlock()
+	return nil
+}
+
+// packetID returns packet ID of a stored packet.
+func packetID(p packet.Packet) packet.ID {
+	switch v := p.(type) {
+	case *packet.Publish:
+		return v.PacketID
+	case *packet.PubRel:
+		return v.PacketID
+	default:
+		return 0
+	}
+}
+
+func sortStoredPackets(list []*storedPacket) []packet.Packet {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].seq < list[j].seq
+	})
+	packets := make([]packet.Packet, len(list))
+	for i, sp := range list {
+		packets[i] = sp.p
+	}
+	return packets
+}
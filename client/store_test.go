@@ -0,0 +1,179 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/koron/go-mqtt/packet"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	p1 := &packet.Publish{QoS: packet.QAtLeastOnce, TopicName: "a", PacketID: 3, Payload: []byte("1")}
+	p2 := &packet.Publish{QoS: packet.QExactlyOnce, TopicName: "b", PacketID: 1, Payload: []byte("2")}
+	p3 := &packet.Publish{QoS: packet.QAtLeastOnce, TopicName: "c", PacketID: 2, Payload: []byte("3")}
+	for _, p := range []*packet.Publish{p1, p2, p3} {
+		if err := s.PutOutgoing(p.PacketID, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// replace keeps order.
+	rel := &packet.PubRel{PacketID: 1}
+	if err := s.PutOutgoing(1, rel); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteOutgoing(2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Outgoings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]packet.Packet{p1, rel}, got); d != "" {
+		t.Errorf("unexpected outgoings: -want +got\n%s", d)
+	}
+
+	if err := s.PutIncoming(10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.HasIncoming(10); err != nil || !ok {
+		t.Errorf("incoming 10 should be recorded: %v", err)
+	}
+	if err := s.DeleteIncoming(10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.HasIncoming(10); err != nil || ok {
+		t.Errorf("incoming 10 should be deleted: %v", err)
+	}
+	if err := s.PutIncoming(11); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ClearIncoming(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.HasIncoming(11); err != nil || ok {
+		t.Errorf("incoming 11 should be cleared: %v", err)
+	}
+
+	if err := s.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Outgoings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("outgoings are left after Reset: %+v", got)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
+
+func TestFileStore_Restore(t *testing.T) {
+	testFileStoreRestore(t, true)
+}
+
+func TestFileStore_RestoreWithoutSession(t *testing.T) {
+	testFileStoreRestore(t, false)
+}
+
+func testFileStoreRestore(t *testing.T, sessionPresent bool) {
+	dir := t.TempDir()
+	s1, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, tc1 := connectTestBroker(t, Param{
+		Options: &Options{
+			KeepAlive:            60,
+			DisableAutoKeepAlive: true,
+			Store:                s1,
+		},
+	})
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c1.Publish(AtLeastOnce, false, "a/b", []byte("persistent"))
+	}()
+	p1, ok := tc1.recv().(*packet.Publish)
+	if !ok {
+		t.Fatal("not PUBLISH")
+	}
+	// the process is terminated before PUBACK.
+	c1.Disconnect(true)
+	if err := <-ch; err == nil {
+		t.Fatal("Publish should fail by termination")
+	}
+
+	s2, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newTestBroker(t)
+	cch := make(chan Client, 1)
+	go func() {
+		c2, _ := Connect(Param{
+			ID:   "testclient",
+			Addr: b.addr(),
+			Options: &Options{
+				KeepAlive:            60,
+				DisableAutoKeepAlive: true,
+				Store:                s2,
+			},
+		})
+		cch <- c2
+	}()
+	tc2 := b.accept(&packet.ConnACK{
+		SessionPresent: sessionPresent,
+		ReturnCode:     packet.ConnectAccept,
+	})
+	c2 := <-cch
+	if c2 == nil {
+		t.Fatal("failed to connect")
+	}
+	defer c2.Disconnect(true)
+	if !sessionPresent {
+		// the broker lost the session, so stored packets are discarded.
+		tc2.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if p, err := packet.SplitDecode(tc2.r); err == nil {
+			t.Fatalf("unexpected resent packet: %+v", p)
+		}
+		if got, _ := s2.Outgoings(); len(got) != 0 {
+			t.Fatalf("stored packets are left: %+v", got)
+		}
+		return
+	}
+	p2, ok := tc2.recv().(*packet.Publish)
+	if !ok {
+		t.Fatal("not PUBLISH")
+	}
+	if !p2.Dup || p2.PacketID != p1.PacketID || string(p2.Payload) != "persistent" {
+		t.Fatalf("unexpected resent PUBLISH: %+v", p2)
+	}
+	tc2.send(&packet.PubACK{PacketID: p2.PacketID})
+
+	// the stored packet is removed by PUBACK.
+	for i := 0; ; i++ {
+		got, err := s2.Outgoings()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 0 {
+			break
+		}
+		if i >= 100 {
+			t.Fatalf("stored packets are left: %+v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,6 +1,9 @@
 package client
 
-import "github.com/koron/go-mqtt/packet"
+import (
+	"github.com/koron/go-mqtt/mqtopic"
+	"github.com/koron/go-mqtt/packet"
+)
 
 // Topic represents a topic filter fanned in.
 type Topic struct {
@@ -10,9 +13,19 @@ type Topic struct {
 
 	// QoS is required QoS for this topic filter.
 	QoS QoS
+
+	// Handler is called for messages which match with Filter, instead of
+	// Param.OnPublish or the buffer for Read (option).  It is removed by
+	// Unsubscribe.
+	Handler PublishedFunc
 }
 
 func (t *Topic) packetTopic() (packet.Topic, error) {
+	if t.Handler != nil {
+		if _, err := mqtopic.ParseFilter(t.Filter); err != nil {
+			return packet.Topic{}, err
+		}
+	}
 	return packet.Topic{
 		Filter:       t.Filter,
 		RequestedQoS: t.QoS.qos(),
@@ -30,3 +43,22 @@ func packetTopics(topics []Topic) ([]packet.Topic, error) {
 	}
 	return array, nil
 }
+
+func filters(topics []Topic) []string {
+	list := make([]string, len(topics))
+	for i, t := range topics {
+		list[i] = t.Filter
+	}
+	return list
+}
+
+// failedFilters returns filters which failed to subscribe.
+func failedFilters(topics []Topic, results []QoS) []string {
+	var list []string
+	for i, t := range topics {
+		if i >= len(results) || results[i] == Failure {
+			list = append(list, t.Filter)
+		}
+	}
+	return list
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// DialContextFunc makes a network connection like net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial implements proxy.Dialer.
+func (f DialContextFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (f DialContextFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// TransportFunc makes a connection to MQTT broker for a URL.
+type TransportFunc func(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error)
+
+var (
+	transportsLock sync.RWMutex
+	transports     = map[string]TransportFunc{
+		"tcp":  dialTCP,
+		"ssl":  dialTLS,
+		"tcps": dialTLS,
+		"tls":  dialTLS,
+		"ws":   dialWS,
+		"wss":  dialWSS,
+		"unix": dialUnix,
+	}
+)
+
+// RegisterTransport registers a transport for URL scheme.  It overrides the
+// built-in transport for the scheme.
+func RegisterTransport(scheme string, f TransportFunc) {
+	transportsLock.Lock()
+	transports[scheme] = f
+	transportsLock.Unlock()
+}
+
+func findTransport(scheme string) TransportFunc {
+	transportsLock.RLock()
+	defer transportsLock.RUnlock()
+	return transports[scheme]
+}
+
+func dial(ctx context.Context, p Param, addr string) (net.Conn, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	f := findTransport(u.Scheme)
+	if f == nil {
+		return nil, ErrUnknownProtocol
+	}
+	opts := p.options()
+	// ConnectTimeout bounds whole of the transport: TCP connect, TLS
+	// handshake, proxy CONNECT and WebSocket upgrade.
+	if opts.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ConnectTimeout)
+		defer cancel()
+	}
+	return f(ctx, u, opts)
+}
+
+func dialTCP(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error) {
+	d, err := opts.proxyDialContext()
+	if err != nil {
+		return nil, err
+	}
+	return d(ctx, "tcp", u.Host)
+}
+
+func dialTLS(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error) {
+	d, err := opts.proxyDialContext()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return tlsClient(ctx, c, u, opts.TLSConfig)
+}
+
+func dialWS(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error) {
+	d, err := opts.proxyDialContext()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d(ctx, "tcp", hostPort(u, "80"))
+	if err != nil {
+		return nil, err
+	}
+	return wsClient(ctx, c, u, opts)
+}
+
+func dialWSS(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error) {
+	d, err := opts.proxyDialContext()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d(ctx, "tcp", hostPort(u, "443"))
+	if err != nil {
+		return nil, err
+	}
+	c, err = tlsClient(ctx, c, u, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return wsClient(ctx, c, u, opts)
+}
+
+// dialUnix connects to an Unix domain socket, like "unix:///path/to/sock".
+func dialUnix(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error) {
+	return opts.dialContext()(ctx, "unix", u.Host+u.Path)
+}
+
+// tlsClient starts TLS on a connection.  The connection is closed when
+// failed.
+func tlsClient(ctx context.Context, c net.Conn, u *url.URL, cfg *tls.Config) (net.Conn, error) {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = u.Hostname()
+	}
+	tc := tls.Client(c, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// wsClient starts WebSocket on a connection.  The connection is closed when
+// failed.
+func wsClient(ctx context.Context, c net.Conn, u *url.URL, opts *Options) (net.Conn, error) {
+	cnf, err := websocket.NewConfig(u.String(), opts.wsOrigin(u))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	cnf.Protocol = []string{"mqtt"}
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Now())
+	})
+	ws, err := websocket.NewClient(cnf, c)
+	if !stop() || err != nil {
+		c.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// hostPort returns "host:port" of a URL, with default port.
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// dialHTTPProxy makes a connection to addr via HTTP CONNECT proxy.
+func dialHTTPProxy(ctx context.Context, forward DialContextFunc, pu *url.URL, addr string) (net.Conn, error) {
+	var (
+		c   net.Conn
+		err error
+	)
+	if pu.Scheme == "https" {
+		c, err = forward(ctx, "tcp", hostPort(pu, "443"))
+		if err == nil {
+			c, err = tlsClient(ctx, c, pu, nil)
+		}
+	} else {
+		c, err = forward(ctx, "tcp", hostPort(pu, "80"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Now())
+	})
+	br, err := httpConnect(c, pu, addr)
+	if !stop() || err != nil {
+		c.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: c, r: br}, nil
+	}
+	return c, nil
+}
+
+// httpConnect sends CONNECT request and receives its response.
+func httpConnect(c net.Conn, pu *url.URL, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if pu.User != nil {
+		pass, _ := pu.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(pu.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy CONNECT failed: %s", resp.Status)
+	}
+	return br, nil
+}
+
+// bufferedConn is a net.Conn which reads buffered data at first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// connectTransport connects a client to the broker with p.
+func connectTransport(t *testing.T, b *testBroker, p Param) {
+	t.Helper()
+	p.ID = "testclient"
+	ch := make(chan error, 1)
+	go func() {
+		c, err := Connect(p)
+		if err == nil {
+			c.Disconnect(true)
+		}
+		ch <- err
+	}()
+	b.accept(nil)
+	if err := <-ch; err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+}
+
+func TestConnectConn(t *testing.T) {
+	b := newTestBroker(t)
+	conn, err := net.Dial("tcp", b.l.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial failed: %s", err)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		c, err := ConnectConn(context.Background(), conn, Param{ID: "testclient"})
+		if err == nil {
+			c.Disconnect(true)
+		}
+		ch <- err
+	}()
+	b.accept(nil)
+	if err := <-ch; err != nil {
+		t.Fatalf("ConnectConn failed: %s", err)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	b := newTestBroker(t)
+	var addrs []string
+	connectTransport(t, b, Param{
+		Addr: "tcp://broker.invalid:1883",
+		Options: &Options{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				addrs = append(addrs, addr)
+				return net.Dial(network, b.l.Addr().String())
+			},
+		},
+	})
+	if len(addrs) != 1 || addrs[0] != "broker.invalid:1883" {
+		t.Fatalf("unexpected dial: %v", addrs)
+	}
+}
+
+func TestRegisterTransport(t *testing.T) {
+	b := newTestBroker(t)
+	RegisterTransport("testtransport", func(ctx context.Context, u *url.URL, opts *Options) (net.Conn, error) {
+		return net.Dial("tcp", b.l.Addr().String())
+	})
+	connectTransport(t, b, Param{Addr: "testtransport://any"})
+}
+
+func TestUnixTransport(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "mqtt.sock")
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	connectTransport(t, &testBroker{tb: t, l: l}, Param{Addr: "unix://" + name})
+}
+
+func TestHTTPProxy(t *testing.T) {
+	b := newTestBroker(t)
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	t.Cleanup(func() { pl.Close() })
+	ch := make(chan *http.Request, 1)
+	go func() {
+		c, err := pl.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return
+		}
+		ch <- req
+		bc, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			return
+		}
+		defer bc.Close()
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(bc, c)
+		io.Copy(c, bc)
+	}()
+	connectTransport(t, b, Param{
+		Addr: b.addr(),
+		Options: &Options{
+			ProxyURL: "http://user:pass@" + pl.Addr().String(),
+		},
+	})
+	req := <-ch
+	if req.Method != http.MethodConnect || req.Host != b.l.Addr().String() {
+		t.Errorf("unexpected request: %s %s", req.Method, req.Host)
+	}
+	if got := req.Header.Get("Proxy-Authorization"); got != "Basic dXNlcjpwYXNz" {
+		t.Errorf("unexpected Proxy-Authorization: %s", got)
+	}
+}
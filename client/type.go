@@ -1,12 +1,25 @@
 package client
 
+import "context"
+
 // PublishedFunc is called when receive a message.
 type PublishedFunc func(m *Message)
 
+// CredentialsFunc returns username and password to connect.  The password
+// is sent when it is not nil.
+type CredentialsFunc func(ctx context.Context) (username string, password []byte, err error)
+
+// DroppedFunc is called when a received message is dropped.
+type DroppedFunc func(m *Message)
+
 // DisconnectedFunc is called when a connection was lost.
 // reason can be one of Reason or other errors.
 type DisconnectedFunc func(reason error, param Param)
 
+// StateChangedFunc is called when connection state is changed.
+// err is a reason of the change, or nil for Connected.
+type StateChangedFunc func(state State, err error)
+
 // Will represents MQTT's will message.
 type Will struct {
 	QoS     QoS
@@ -36,3 +49,46 @@ func (r Reason) Error() string {
 		return "unknown reason"
 	}
 }
+
+// State represents connection state of the client.
+type State int
+
+const (
+	// Connected shows the client is connected to the broker.
+	Connected State = iota
+
+	// Reconnecting shows the client lost a connection, and tries to connect
+	// again.
+	Reconnecting
+
+	// Disconnected shows the client is disconnected and terminated.
+	Disconnected
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Disconnected:
+		return "disconnected"
+	default:
+		return "unknown state"
+	}
+}
+
+// OverflowPolicy represents behavior when a buffer or a queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest drops the oldest item to accept a new one.
+	DropOldest OverflowPolicy = iota
+
+	// DropNewest drops a new item.  For the offline queue, Publish is
+	// rejected with ErrQueueFull.
+	DropNewest
+
+	// Block blocks until a space is available.
+	Block
+)
@@ -3,26 +3,41 @@ Package backoff provides retry backoff algorithm.
 */
 package backoff
 
-import "time"
+import (
+	"math/rand/v2"
+	"time"
+)
 
 // Exp provides exponential back off.
 type Exp struct {
 	Min time.Duration
 	Max time.Duration
 
+	// Jitter is ratio to randomize each duration, between 0.0 and 1.0.
+	// A duration is shortened randomly up to this ratio.
+	Jitter float64
+
 	count uint32
 }
 
 // Wait sleeps using exponential back off.
 func (exp *Exp) Wait() {
+	time.Sleep(exp.Next())
+}
+
+// Next returns a duration to wait for next, and increases exponential count.
+func (exp *Exp) Next() time.Duration {
 	d := exp.min() * (1 << exp.count)
-	if m := exp.max(); d > m {
+	if m := exp.max(); d > m || d <= 0 {
 		d = m
 	}
-	time.Sleep(d)
+	if j := exp.jitter(); j > 0 {
+		d -= time.Duration(rand.Float64() * j * float64(d))
+	}
 	if exp.count < 31 {
 		exp.count++
 	}
+	return d
 }
 
 // Reset resets exponential count.
@@ -43,3 +58,14 @@ func (exp *Exp) max() time.Duration {
 	}
 	return exp.Max
 }
+
+func (exp *Exp) jitter() float64 {
+	switch {
+	case exp.Jitter <= 0:
+		return 0
+	case exp.Jitter >= 1:
+		return 1
+	default:
+		return exp.Jitter
+	}
+}
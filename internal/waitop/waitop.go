@@ -4,6 +4,7 @@ Package waitop provides wait for executing asynchronous operation.
 package waitop
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
@@ -50,6 +51,15 @@ func New() *WaitOp {
 // Do starts asynchronous operation if it's not started yet.
 // ErrAlreadyDoing and ErrTerminated will be retured.
 func (w *WaitOp) Do(f AsyncOp) (interface{}, error) {
+	return w.DoContext(context.Background(), f)
+}
+
+// DoContext starts asynchronous operation like Do, and stops waiting when ctx
+// is done.  In that case ctx.Err() is returned, and WaitOp become idle again.
+func (w *WaitOp) DoContext(ctx context.Context, f AsyncOp) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// mark as doing.
 	w.c.L.Lock()
 	if w.s != idle {
@@ -69,9 +79,21 @@ func (w *WaitOp) Do(f AsyncOp) (interface{}, error) {
 		w.c.L.Unlock()
 		return nil, err
 	}
+	// wake up the waiter when ctx is done.
+	stop := context.AfterFunc(ctx, func() {
+		w.c.L.Lock()
+		w.c.Broadcast()
+		w.c.L.Unlock()
+	})
+	defer stop()
 	// wait until done.
 	w.c.L.Lock()
 	for w.s != done {
+		if err := ctx.Err(); err != nil {
+			w.s, w.v, w.err = idle, nil, nil
+			w.c.L.Unlock()
+			return nil, err
+		}
 		w.c.Wait()
 	}
 	v, err := w.v, w.err
@@ -80,8 +102,6 @@ func (w *WaitOp) Do(f AsyncOp) (interface{}, error) {
 	return v, err
 }
 
-// TODO: support DoWithContext()
-
 func (w *WaitOp) done(v interface{}, err error) error {
 	rerr := ErrNotDoing
 	w.c.L.Lock()
@@ -1,6 +1,7 @@
 package waitop
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
@@ -205,3 +206,65 @@ func TestDo_Fail(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestDoContext_Cancel(t *testing.T) {
+	op := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	r, err := op.DoContext(ctx, func() error {
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if r != nil {
+		t.Errorf("unexpected result: %v", r)
+	}
+	// canceled WaitOp can be reused.
+	r, err = op.Do(func() error {
+		go op.Fulfill("foo")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := r.(string); !ok || v != "foo" {
+		t.Errorf("unexpected result: %v", r)
+	}
+}
+
+func TestDoContext_Deadline(t *testing.T) {
+	op := New()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := op.DoContext(ctx, func() error {
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = op.Fulfill("foo")
+	if err != ErrNotDoing {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoContext_Done(t *testing.T) {
+	op := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	_, err := op.DoContext(ctx, func() error {
+		called = true
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if called {
+		t.Error("AsyncOp called with done context")
+	}
+}
@@ -41,10 +41,25 @@ func (a *Adapter) Disconnect(srv *server.Server, ca server.ClientAdapter, err er
 		return
 	}
 	a.mu.Lock()
-	delete(a.cas, ca2.id)
+	// the client may be reconnected already with same ID.
+	if a.cas[ca2.id] == ca2 {
+		delete(a.cas, ca2.id)
+	}
 	a.mu.Unlock()
 }
 
+// kick disconnects a client from server side.
+func (a *Adapter) kick(id string) bool {
+	a.mu.Lock()
+	ca, ok := a.cas[id]
+	a.mu.Unlock()
+	if !ok {
+		return false
+	}
+	ca.c.Close()
+	return true
+}
+
 func (a *Adapter) dispatch(src *clientAdapter, m *server.Message) {
 	topic, err := mqtopic.Parse(m.Topic)
 	if err != nil {
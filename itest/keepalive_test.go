@@ -121,3 +121,24 @@ func TestKeepAlive_WithoutMonitor(t *testing.T) {
 		t.Error("client aliving unexpectedly")
 	}
 }
+
+func TestKeepAlive_Zero(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(t, nil, nil).Start()
+
+	// zero keep alive turns off the monitor of the server.
+	c0 := srv.Connect(t, client.Param{
+		Options: &client.Options{
+			KeepAlive: 0,
+		},
+	})
+
+	time.Sleep(time.Second)
+
+	if err := c0.DisconnectReason(); err != nil {
+		t.Errorf("disconnected unexpectedly: %s", err)
+	}
+
+	srv.Stop()
+}
@@ -0,0 +1,152 @@
+package itest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/koron/go-mqtt/broker"
+	"github.com/koron/go-mqtt/client"
+	"github.com/koron/go-mqtt/packet"
+	"github.com/koron/go-mqtt/server"
+)
+
+// listenerAdapter records names of listeners which accepted clients.
+type listenerAdapter struct {
+	broker.Broker
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func (a *listenerAdapter) Connect(srv *server.Server, c server.Client, p *packet.Connect) (server.ClientAdapter, error) {
+	a.mu.Lock()
+	a.names[p.ClientID] = c.Listener().Name
+	a.mu.Unlock()
+	return a.Broker.Connect(srv, c, p)
+}
+
+func TestMultipleListeners(t *testing.T) {
+	t.Parallel()
+	tcpAddr := "tcp://" + freeHostPort(t)
+	wsAddr := "ws://" + freeHostPort(t) + "/mqtt"
+	a := &listenerAdapter{names: map[string]string{}}
+	srv := &server.Server{
+		Adapter: a,
+		Listeners: []*server.Listener{
+			{Name: "tcp", Addr: tcpAddr},
+			{Name: "ws", Addr: wsAddr},
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ListenAndServe() }()
+	defer func() {
+		srv.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ListenAndServe failed: %s", err)
+		}
+	}()
+	waitListen(t, tcpAddr[len("tcp://"):], done)
+	waitListen(t, wsAddr[len("ws://"):len(wsAddr)-len("/mqtt")], done)
+
+	connect := func(id, addr string) client.Client {
+		c, err := client.Connect(client.Param{
+			ID:      id,
+			Addr:    addr,
+			Options: &client.Options{CleanSession: true, KeepAlive: 60},
+		})
+		if err != nil {
+			t.Fatalf("client.Connect failed: %s", err)
+		}
+		t.Cleanup(func() { c.Disconnect(false) })
+		return c
+	}
+	c0 := connect("c0", tcpAddr)
+	if err := c0.Subscribe([]client.Topic{{Filter: "#", QoS: client.AtLeastOnce}}); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+	c1 := connect("c1", wsAddr)
+	if err := c1.Publish(client.AtLeastOnce, false, "from/ws", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	m, err := c0.Read(true)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if m.Topic != "from/ws" || string(m.Body) != "hello" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+
+	a.mu.Lock()
+	if a.names["c0"] != "tcp" || a.names["c1"] != "ws" {
+		t.Errorf("unexpected listeners: %v", a.names)
+	}
+	a.mu.Unlock()
+}
+
+func TestMixedListeners(t *testing.T) {
+	t.Parallel()
+	tcpAddr := "tcp://" + freeHostPort(t)
+	wl := server.NewWebSocketListener(nil)
+	mux := http.NewServeMux()
+	mux.Handle("/mqtt", wl)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+	wsAddr := "ws://" + hs.Listener.Addr().String() + "/mqtt"
+
+	a := &listenerAdapter{names: map[string]string{}}
+	srv := &server.Server{
+		Adapter: a,
+		Listeners: []*server.Listener{
+			{Name: "tcp", Addr: tcpAddr},
+			{Name: "mounted", Listener: wl},
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ListenAndServe() }()
+	defer func() {
+		srv.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ListenAndServe failed: %s", err)
+		}
+	}()
+	waitListen(t, tcpAddr[len("tcp://"):], done)
+
+	c0, err := client.Connect(client.Param{
+		ID:      "c0",
+		Addr:    tcpAddr,
+		Options: &client.Options{CleanSession: true, KeepAlive: 60},
+	})
+	if err != nil {
+		t.Fatalf("client.Connect failed: %s", err)
+	}
+	defer c0.Disconnect(false)
+	if err := c0.Subscribe([]client.Topic{{Filter: "#", QoS: client.AtLeastOnce}}); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+	c1, err := client.Connect(client.Param{
+		ID:      "c1",
+		Addr:    wsAddr,
+		Options: &client.Options{CleanSession: true, KeepAlive: 60},
+	})
+	if err != nil {
+		t.Fatalf("client.Connect failed: %s", err)
+	}
+	defer c1.Disconnect(false)
+	if err := c1.Publish(client.AtLeastOnce, false, "from/mounted", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	m, err := c0.Read(true)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if m.Topic != "from/mounted" || string(m.Body) != "hello" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+
+	a.mu.Lock()
+	if a.names["c0"] != "tcp" || a.names["c1"] != "mounted" {
+		t.Errorf("unexpected listeners: %v", a.names)
+	}
+	a.mu.Unlock()
+}
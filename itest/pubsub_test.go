@@ -7,8 +7,10 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/koron/go-mqtt/client"
+	"github.com/koron/go-mqtt/server"
 )
 
 func TestPubSub(t *testing.T) {
@@ -36,6 +38,11 @@ func TestPubSub(t *testing.T) {
 			ch <- fmt.Errorf("c0.C.Read() failed: %w", err)
 			return
 		}
+		if m.ReceivedAt.IsZero() {
+			ch <- fmt.Errorf("ReceivedAt is not set: %+v", m)
+			return
+		}
+		m.ReceivedAt = time.Time{}
 		if !reflect.DeepEqual(m, &client.Message{
 			Topic: "users/123/objects/789",
 			Body:  []byte("Hello MQTT"),
@@ -68,3 +75,37 @@ func TestPubSub(t *testing.T) {
 
 	srv.Stop()
 }
+
+func TestPublishToClosedClient(t *testing.T) {
+	t.Parallel()
+	a := &Adapter{}
+	srv := NewServer(t, a, nil).Start()
+	defer srv.Stop()
+
+	c0 := srv.Connect(t, client.Param{
+		Options: &client.Options{CleanSession: true, KeepAlive: 60},
+	})
+	a.mu.Lock()
+	sc := a.cas[c0.ID].c
+	a.mu.Unlock()
+	c0.Disconnect(t, false)
+
+	// wait the server detects disconnection.
+	for i := 0; ; i++ {
+		a.mu.Lock()
+		_, ok := a.cas[c0.ID]
+		a.mu.Unlock()
+		if !ok {
+			break
+		}
+		if i >= 100 {
+			t.Fatal("disconnection is not detected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// publish to the closed client fails, without panic.
+	if err := sc.Publish(server.AtMostOnce, false, "a", []byte("x")); err != server.ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
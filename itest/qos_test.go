@@ -0,0 +1,266 @@
+package itest
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/koron/go-mqtt/broker"
+	"github.com/koron/go-mqtt/client"
+	"github.com/koron/go-mqtt/packet"
+	"github.com/koron/go-mqtt/server"
+)
+
+// deliveryAdapter records results of deliveries.
+type deliveryAdapter struct {
+	broker.Broker
+	mu      sync.Mutex
+	results []error
+	ch      chan struct{}
+}
+
+func (a *deliveryAdapter) Connect(srv *server.Server, c server.Client, p *packet.Connect) (server.ClientAdapter, error) {
+	ca, err := a.Broker.Connect(srv, c, p)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryClientAdapter{ClientAdapter: ca, a: a}, nil
+}
+
+func (a *deliveryAdapter) Disconnect(srv *server.Server, ca server.ClientAdapter, err error) {
+	if ca2, ok := ca.(*deliveryClientAdapter); ok {
+		ca = ca2.ClientAdapter
+	}
+	a.Broker.Disconnect(srv, ca, err)
+}
+
+type deliveryClientAdapter struct {
+	server.ClientAdapter
+	a *deliveryAdapter
+}
+
+func (ca *deliveryClientAdapter) OnDelivery(m *server.Message, err error) {
+	ca.a.mu.Lock()
+	ca.a.results = append(ca.a.results, err)
+	ca.a.mu.Unlock()
+	ca.a.ch <- struct{}{}
+}
+
+// rawClient is a MQTT client which sends and receives packets directly.
+type rawClient struct {
+	tb   testing.TB
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRaw(tb testing.TB, srv *Server, p *packet.Connect) (*rawClient, *packet.ConnACK) {
+	tb.Helper()
+	conn, err := net.Dial("tcp", srv.l.Addr().String())
+	if err != nil {
+		tb.Fatalf("net.Dial failed: %s", err)
+	}
+	rc := &rawClient{tb: tb, conn: conn, r: bufio.NewReader(conn)}
+	rc.send(p)
+	ack, ok := rc.recv().(*packet.ConnACK)
+	if !ok {
+		tb.Fatal("CONNACK expected")
+	}
+	return rc, ack
+}
+
+func (rc *rawClient) send(p packet.Packet) {
+	rc.tb.Helper()
+	b, err := p.Encode()
+	if err != nil {
+		rc.tb.Fatalf("failed to encode: %s", err)
+	}
+	if _, err := rc.conn.Write(b); err != nil {
+		rc.tb.Fatalf("failed to write: %s", err)
+	}
+}
+
+func (rc *rawClient) recv() packet.Packet {
+	rc.tb.Helper()
+	p, err := packet.SplitDecode(rc.r)
+	if err != nil {
+		rc.tb.Fatalf("failed to read: %s", err)
+	}
+	return p
+}
+
+func TestQoS1Outbound(t *testing.T) {
+	t.Parallel()
+	a := &deliveryAdapter{ch: make(chan struct{}, 1)}
+	srv := NewServer(t, a, nil).Start()
+	defer srv.Stop()
+
+	connect := &packet.Connect{ClientID: "sub", Version: 4, KeepAlive: 60}
+	sub, ack := dialRaw(t, srv, connect)
+	if ack.SessionPresent {
+		t.Error("session should not be present")
+	}
+	sub.send(&packet.Subscribe{
+		PacketID: 1,
+		Topics:   []packet.Topic{{Filter: "q", RequestedQoS: packet.QAtLeastOnce}},
+	})
+	if p := sub.recv().(*packet.SubACK); p.Results[0] != packet.SubscribeAtLeastOnce {
+		t.Fatalf("unexpected SUBACK: %+v", p)
+	}
+
+	pub := srv.Connect(t, client.Param{Options: &client.Options{CleanSession: true, KeepAlive: 60}})
+	defer pub.Disconnect(t, false)
+	if err := pub.C.Publish(client.AtLeastOnce, false, "q", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+
+	// drop the connection without PUBACK.
+	p0 := sub.recv().(*packet.Publish)
+	if p0.QoS != packet.QAtLeastOnce || p0.Dup || p0.PacketID == 0 {
+		t.Fatalf("unexpected PUBLISH: %+v", p0)
+	}
+	sub.conn.Close()
+
+	// resent with DUP flag after reconnected.
+	sub, ack = dialRaw(t, srv, connect)
+	defer sub.conn.Close()
+	if !ack.SessionPresent {
+		t.Error("session should be present")
+	}
+	p1 := sub.recv().(*packet.Publish)
+	if !p1.Dup || p1.PacketID != p0.PacketID || string(p1.Payload) != "hello" {
+		t.Fatalf("unexpected PUBLISH: %+v", p1)
+	}
+	sub.send(&packet.PubACK{PacketID: p1.PacketID})
+	<-a.ch
+	a.mu.Lock()
+	if len(a.results) != 1 || a.results[0] != nil {
+		t.Errorf("unexpected results: %v", a.results)
+	}
+	a.mu.Unlock()
+}
+
+func TestQoS2Inbound(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t, &broker.Broker{}, nil).Start()
+	defer srv.Stop()
+
+	sub := srv.Connect(t, client.Param{Options: &client.Options{CleanSession: true, KeepAlive: 60}})
+	defer sub.Disconnect(t, false)
+	err := sub.C.Subscribe([]client.Topic{{Filter: "q", QoS: client.ExactlyOnce}})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+
+	pub, _ := dialRaw(t, srv, &packet.Connect{ClientID: "pub", Version: 4, CleanSession: true, KeepAlive: 60})
+	defer pub.conn.Close()
+	p := &packet.Publish{QoS: packet.QExactlyOnce, TopicName: "q", PacketID: 5, Payload: []byte("once")}
+	pub.send(p)
+	if r := pub.recv().(*packet.PubRec); r.PacketID != 5 {
+		t.Fatalf("unexpected PUBREC: %+v", r)
+	}
+	// duplicated PUBLISH is not delivered again.
+	p.Dup = true
+	pub.send(p)
+	if r := pub.recv().(*packet.PubRec); r.PacketID != 5 {
+		t.Fatalf("unexpected PUBREC: %+v", r)
+	}
+	pub.send(&packet.PubRel{PacketID: 5})
+	if r := pub.recv().(*packet.PubComp); r.PacketID != 5 {
+		t.Fatalf("unexpected PUBCOMP: %+v", r)
+	}
+	// the packet ID can be reused after released.
+	pub.send(&packet.Publish{QoS: packet.QExactlyOnce, TopicName: "q", PacketID: 5, Payload: []byte("twice")})
+	pub.recv()
+
+	for _, want := range []string{"once", "twice"} {
+		m, err := sub.C.Read(true)
+		if err != nil {
+			t.Fatalf("Read failed: %s", err)
+		}
+		if string(m.Body) != want || m.QoS != client.ExactlyOnce {
+			t.Errorf("unexpected message: %+v", m)
+		}
+	}
+}
+
+func TestQoS2Outbound(t *testing.T) {
+	t.Parallel()
+	a := &deliveryAdapter{ch: make(chan struct{}, 1)}
+	srv := NewServer(t, a, nil).Start()
+	defer srv.Stop()
+
+	connect := &packet.Connect{ClientID: "sub", Version: 4, KeepAlive: 60}
+	sub, _ := dialRaw(t, srv, connect)
+	sub.send(&packet.Subscribe{
+		PacketID: 1,
+		Topics:   []packet.Topic{{Filter: "q", RequestedQoS: packet.QExactlyOnce}},
+	})
+	if p := sub.recv().(*packet.SubACK); p.Results[0] != packet.SubscribeExactOnce {
+		t.Fatalf("unexpected SUBACK: %+v", p)
+	}
+
+	pub := srv.Connect(t, client.Param{Options: &client.Options{CleanSession: true, KeepAlive: 60}})
+	defer pub.Disconnect(t, false)
+	if err := pub.C.Publish(client.ExactlyOnce, false, "q", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+
+	p0 := sub.recv().(*packet.Publish)
+	if p0.QoS != packet.QExactlyOnce || p0.PacketID == 0 {
+		t.Fatalf("unexpected PUBLISH: %+v", p0)
+	}
+	sub.send(&packet.PubRec{PacketID: p0.PacketID})
+	if r := sub.recv().(*packet.PubRel); r.PacketID != p0.PacketID {
+		t.Fatalf("unexpected PUBREL: %+v", r)
+	}
+	// drop the connection without PUBCOMP.
+	sub.conn.Close()
+
+	// PUBREL is resent instead of PUBLISH after reconnected.
+	sub, _ = dialRaw(t, srv, connect)
+	defer sub.conn.Close()
+	if r := sub.recv().(*packet.PubRel); r.PacketID != p0.PacketID {
+		t.Fatalf("unexpected PUBREL: %+v", r)
+	}
+	sub.send(&packet.PubComp{PacketID: p0.PacketID})
+	<-a.ch
+	a.mu.Lock()
+	if len(a.results) != 1 || a.results[0] != nil {
+		t.Errorf("unexpected results: %v", a.results)
+	}
+	a.mu.Unlock()
+}
+
+// welcomeAdapter publishes messages to a client in Connect.
+type welcomeAdapter struct {
+	broker.Broker
+}
+
+func (a *welcomeAdapter) Connect(srv *server.Server, c server.Client, p *packet.Connect) (server.ClientAdapter, error) {
+	for _, qos := range []server.QoS{server.AtLeastOnce, server.AtMostOnce, server.AtMostOnce, server.ExactlyOnce} {
+		if err := c.Publish(qos, false, "welcome", []byte{byte('0' + qos)}); err != nil {
+			return nil, err
+		}
+	}
+	return a.Broker.Connect(srv, c, p)
+}
+
+func TestPublishInConnect(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t, &welcomeAdapter{}, nil).Start()
+	defer srv.Stop()
+
+	rc, ack := dialRaw(t, srv, &packet.Connect{ClientID: "c0", Version: 4, CleanSession: true, KeepAlive: 60})
+	defer rc.conn.Close()
+	if ack.ReturnCode != packet.ConnectAccept {
+		t.Fatalf("unexpected CONNACK: %+v", ack)
+	}
+	// messages are sent after CONNACK in order.
+	for _, want := range []packet.QoS{packet.QAtLeastOnce, packet.QAtMostOnce, packet.QAtMostOnce, packet.QExactlyOnce} {
+		p, ok := rc.recv().(*packet.Publish)
+		if !ok || p.QoS != want || p.TopicName != "welcome" {
+			t.Fatalf("unexpected packet: %+v", p)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package itest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/client"
+)
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+	a := &Adapter{}
+	srv := NewServer(t, a, nil).Start()
+
+	states := make(chan client.State, 10)
+	c0 := srv.Connect(t, client.Param{
+		Options: &client.Options{
+			CleanSession:      true,
+			KeepAlive:         60,
+			AutoReconnect:     true,
+			ReconnectMinDelay: time.Millisecond * 10,
+		},
+		OnStateChange: func(s client.State, err error) {
+			states <- s
+		},
+	})
+	err := c0.C.Subscribe([]client.Topic{
+		{Filter: "users/#", QoS: client.AtMostOnce},
+	})
+	if err != nil {
+		t.Fatalf("c0.Subscribe() failed: %s", err)
+	}
+
+	if !a.kick(c0.ID) {
+		t.Fatal("failed to kick c0")
+	}
+	if s := <-states; s != client.Reconnecting {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	if s := <-states; s != client.Connected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+
+	c1 := srv.Connect(t, client.Param{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	// retry to publish until resubscription is done.
+	var m *client.Message
+	for m == nil {
+		err := c1.C.Publish(client.AtMostOnce, false, "users/123", []byte("Hello again"))
+		if err != nil {
+			t.Fatalf("c1.Publish() failed: %s", err)
+		}
+		ctx2, cancel2 := context.WithTimeout(ctx, time.Millisecond*100)
+		m, err = c0.C.ReadContext(ctx2)
+		cancel2()
+		if err != nil && ctx.Err() != nil {
+			t.Fatalf("c0.ReadContext() failed: %s", err)
+		}
+	}
+	if m.Topic != "users/123" || string(m.Body) != "Hello again" {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+
+	c1.Disconnect(t, false)
+	c0.Disconnect(t, false)
+	if s := <-states; s != client.Disconnected {
+		t.Fatalf("unexpected state: %s", s)
+	}
+	if err := c0.DisconnectReason(); err != client.Explicitly {
+		t.Errorf("unexpected disconnect reason: %v", err)
+	}
+	srv.Stop()
+}
@@ -0,0 +1,176 @@
+package itest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/koron/go-mqtt/broker"
+	"github.com/koron/go-mqtt/client"
+	"github.com/koron/go-mqtt/server"
+	"golang.org/x/net/websocket"
+)
+
+func testWebSocketPubSub(t *testing.T, addr string) {
+	t.Helper()
+	connect := func(id string) client.Client {
+		c, err := client.Connect(client.Param{
+			ID:      id,
+			Addr:    addr,
+			Options: &client.Options{CleanSession: true, KeepAlive: 60},
+		})
+		if err != nil {
+			t.Fatalf("client.Connect failed: %s", err)
+		}
+		t.Cleanup(func() { c.Disconnect(false) })
+		return c
+	}
+	c0 := connect("ws0")
+	if err := c0.Subscribe([]client.Topic{{Filter: "ws/#", QoS: client.AtLeastOnce}}); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+	c1 := connect("ws1")
+	if err := c1.Publish(client.AtLeastOnce, false, "ws/1", []byte("over websocket")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	m, err := c0.Read(true)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if m.Topic != "ws/1" || string(m.Body) != "over websocket" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	t.Parallel()
+	l := server.NewWebSocketListener(nil)
+	mux := http.NewServeMux()
+	mux.Handle("/mqtt", l)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	srv := &server.Server{Adapter: &broker.Broker{}}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	defer func() {
+		srv.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve failed: %s", err)
+		}
+	}()
+
+	addr := "ws://" + hs.Listener.Addr().String() + "/mqtt"
+	testWebSocketPubSub(t, addr)
+
+	// "mqtt" subprotocol is selected.
+	ws, err := websocket.Dial(addr, "mqtt", hs.URL)
+	if err != nil {
+		t.Fatalf("websocket.Dial failed: %s", err)
+	}
+	defer ws.Close()
+	if p := ws.Config().Protocol; len(p) != 1 || p[0] != "mqtt" {
+		t.Errorf("unexpected subprotocol: %v", p)
+	}
+}
+
+func TestWebSocketCheckOrigin(t *testing.T) {
+	t.Parallel()
+	dial := func(l *server.WebSocketListener, origin string) error {
+		hs := httptest.NewServer(l)
+		defer hs.Close()
+		ws, err := websocket.Dial("ws://"+hs.Listener.Addr().String()+"/", "mqtt", origin)
+		if err != nil {
+			return err
+		}
+		return ws.Close()
+	}
+
+	// other origins are rejected by default.
+	if err := dial(server.NewWebSocketListener(nil), "http://evil.example.com"); err == nil {
+		t.Error("cross origin request is accepted")
+	}
+
+	l := server.NewWebSocketListener(nil)
+	l.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "http://trusted.example.com"
+	}
+	if err := dial(l, "http://trusted.example.com"); err != nil {
+		t.Errorf("websocket.Dial failed: %s", err)
+	}
+	if err := dial(l, "http://evil.example.com"); err == nil {
+		t.Error("untrusted origin is accepted")
+	}
+}
+
+func TestWebSocketListenAndServe(t *testing.T) {
+	t.Parallel()
+	hostPort := freeHostPort(t)
+
+	srv := &server.Server{
+		Addr:    "ws://" + hostPort + "/mqtt",
+		Adapter: &broker.Broker{},
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ListenAndServe() }()
+	defer func() {
+		srv.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ListenAndServe failed: %s", err)
+		}
+	}()
+
+	waitListen(t, hostPort, done)
+	testWebSocketPubSub(t, srv.Addr)
+}
+
+func TestListenTLSWithoutCertificates(t *testing.T) {
+	t.Parallel()
+	for _, scheme := range []string{"tls", "wss"} {
+		srv := &server.Server{
+			Addr:    scheme + "://" + freeHostPort(t),
+			Adapter: &broker.Broker{},
+		}
+		done := make(chan error, 1)
+		go func() { done <- srv.ListenAndServe() }()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%s: ListenAndServe succeeded unexpectedly", scheme)
+			}
+		case <-time.After(5 * time.Second):
+			srv.Close()
+			t.Errorf("%s: ListenAndServe accepted invalid TLS config", scheme)
+		}
+	}
+}
+
+// freeHostPort returns an available "host:port" to listen.
+func freeHostPort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitListen waits for the server to start listening on hostPort.
+func waitListen(t *testing.T, hostPort string, done <-chan error) {
+	t.Helper()
+	for {
+		conn, err := net.Dial("tcp", hostPort)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("ListenAndServe failed: %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
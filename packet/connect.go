@@ -17,7 +17,7 @@ type Connect struct {
 	ClientID     string
 	Version      uint8
 	Username     *string
-	Password     *string
+	Password     []byte // binary data, nil means no password
 	CleanSession bool
 	KeepAlive    uint16
 	WillFlag     bool
@@ -60,7 +60,7 @@ func (p *Connect) Encode() ([]byte, error) {
 		connectFlags |= 0x80
 	}
 	if p.Password != nil {
-		password = encodeString(*p.Password)
+		password = encodeBinary(p.Password)
 		if password == nil {
 			return nil, errors.New("too long Password")
 		}
@@ -129,7 +129,7 @@ func (p *Connect) Decode(b []byte) error {
 		willTopic   string
 		willMessage string
 		username    *string
-		password    *string
+		password    []byte
 	)
 	if willFlag {
 		willTopic, err = d.readString()
@@ -149,11 +149,10 @@ func (p *Connect) Decode(b []byte) error {
 		username = &s
 	}
 	if passwordFlag {
-		s, err := d.readString()
+		password, err = d.readBinary()
 		if err != nil {
 			return err
 		}
-		password = &s
 	}
 	if err := d.finish(); err != nil {
 		return err
@@ -62,7 +62,7 @@ func TestConnect(t *testing.T) {
 				ClientID:     "go-mqtt",
 				Version:      4,
 				Username:     str2ptr("username"),
-				Password:     str2ptr("verysecret"),
+				Password:     []byte("verysecret"),
 				CleanSession: true,
 				KeepAlive:    10,
 				WillFlag:     true,
@@ -106,7 +106,51 @@ func TestConnect(t *testing.T) {
 				ClientID:     "go-mqtt",
 				Version:      4,
 				Username:     str2ptr(""),
-				Password:     str2ptr(""),
+				Password:     []byte{},
+				CleanSession: true,
+				KeepAlive:    10,
+				WillFlag:     true,
+				WillQoS:      QAtLeastOnce,
+				WillRetain:   false,
+				WillTopic:    "will",
+				WillMessage:  "send me home",
+			},
+		)
+	})
+
+	t.Run("binary password", func(t *testing.T) {
+		testConnect(t,
+			[]byte{
+				0x10,
+				45,
+				0, // Length MSB (0)
+				4, // Length LSB (4)
+				'M', 'Q', 'T', 'T',
+				4,    // Protocol level 4
+				0xce, // connect flags 11001110, will QoS = 01
+				0,    // Keep Alive MSB (0)
+				10,   // Keep Alive LSB (10)
+				0,    // Client ID MSB (0)
+				7,    // Client ID LSB (7)
+				'g', 'o', '-', 'm', 'q', 't', 't',
+				0, // Will Topic MSB (0)
+				4, // Will Topic LSB (4)
+				'w', 'i', 'l', 'l',
+				0,  // Will Message MSB (0)
+				12, // Will Message LSB (12)
+				's', 'e', 'n', 'd', ' ', 'm', 'e', ' ', 'h', 'o', 'm', 'e',
+				0, // Username ID MSB (0)
+				0, // Username ID LSB (0)
+				// zero-length username
+				0, // Password ID MSB (0)
+				2, // Password ID LSB (2)
+				0x00, 0xff,
+			},
+			Connect{
+				ClientID:     "go-mqtt",
+				Version:      4,
+				Username:     str2ptr(""),
+				Password:     []byte{0x00, 0xff},
 				CleanSession: true,
 				KeepAlive:    10,
 				WillFlag:     true,
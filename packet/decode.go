@@ -151,29 +151,39 @@ func (d *decoder) readPacketID() (ID, error) {
 }
 
 func (d *decoder) readString() (string, error) {
+	b, err := d.readBinary()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readBinary reads length prefixed binary data.  It returns non-nil empty
+// slice for zero length data.
+func (d *decoder) readBinary() ([]byte, error) {
 	ul, err := d.readUint16()
 	if err != nil {
 		if err == errInsufficientUint16 {
 			err = errInsufficientString
 		}
-		return "", err
-	}
-	if ul == 0 {
-		return "", nil
+		return nil, err
 	}
 	l := int(ul)
 	b := make([]byte, l)
+	if l == 0 {
+		return b, nil
+	}
 	n, err := d.r.Read(b)
 	if err != nil {
 		if err == io.EOF {
 			err = errInsufficientString
 		}
-		return "", err
+		return nil, err
 	}
 	if n != l {
-		return "", errInsufficientString
+		return nil, errInsufficientString
 	}
-	return string(b), nil
+	return b, nil
 }
 
 func (d *decoder) readStrings() ([]string, error) {
@@ -209,6 +219,9 @@ func (d *decoder) readSubscribeResults() ([]SubscribeResult, error) {
 
 func (d *decoder) readRemainBytes() ([]byte, error) {
 	b := make([]byte, d.r.Len())
+	if len(b) == 0 {
+		return b, nil
+	}
 	n, err := d.r.Read(b)
 	if err != nil {
 		return nil, err
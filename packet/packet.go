@@ -29,13 +29,19 @@ func encodeUint16(n uint16) []byte {
 }
 
 func encodeString(s string) []byte {
-	l := len(s)
-	if len(s) > math.MaxUint16 {
+	return encodeBinary([]byte(s))
+}
+
+// encodeBinary encodes binary data with length prefix.  It returns nil when
+// the data is too long.
+func encodeBinary(d []byte) []byte {
+	l := len(d)
+	if l > math.MaxUint16 {
 		return nil
 	}
 	b := make([]byte, l+2)
 	b[0] = byte(l >> 8 & 0xff)
 	b[1] = byte(l >> 0 & 0xff)
-	copy(b[2:], []byte(s))
+	copy(b[2:], d)
 	return b
 }
@@ -1,6 +1,9 @@
 package packet
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestPublish(t *testing.T) {
 	data := []byte{
@@ -42,6 +45,59 @@ func TestPublish(t *testing.T) {
 	compareBytes(t, b, data)
 }
 
+func TestPublishEmptyPayload(t *testing.T) {
+	data := []byte{
+		0x31, 0x09,
+		0x00, 0x07,
+		'g', 'o', '-', 'm', 'q', 't', 't',
+	}
+	p := Publish{}
+	err := p.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TopicName != "go-mqtt" {
+		t.Errorf("unexpected TopicName: %q", p.TopicName)
+	}
+	if len(p.Payload) != 0 {
+		t.Errorf("unexpected Payload: %v", p.Payload)
+	}
+	if !p.Retain {
+		t.Errorf("unexpected Retain: %v", p.Retain)
+	}
+
+	// encode test.
+	b, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareBytes(t, b, data)
+}
+
+func TestPublishEmptyPayloadQoS1(t *testing.T) {
+	data := []byte{
+		0x32, 0x0b,
+		0x00, 0x07,
+		'g', 'o', '-', 'm', 'q', 't', 't',
+		0x00, 0x07,
+	}
+	// decode from a stream.
+	p, err := SplitDecode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, ok := p.(*Publish)
+	if !ok {
+		t.Fatalf("not PUBLISH: %+v", p)
+	}
+	if pub.PacketID != 7 || pub.QoS != QAtLeastOnce {
+		t.Errorf("unexpected PUBLISH: %+v", pub)
+	}
+	if len(pub.Payload) != 0 {
+		t.Errorf("unexpected Payload: %v", pub.Payload)
+	}
+}
+
 func TestPubACK(t *testing.T) {
 	data := []byte{0x40, 0x02, 0x00, 0x07}
 	p := PubACK{}
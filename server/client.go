@@ -13,12 +13,18 @@ import (
 
 // Client provides interface to client connection.
 type Client interface {
-	// Publish publishes a message to the client.
+	// Publish publishes a message to the client.  QoS 1 and 2 messages are
+	// held until acknowledged, and resent when the client reconnects with the
+	// session.  Messages published before CONNACK is sent, for example in
+	// Adapter#Connect(), are held and sent after CONNACK.
 	Publish(qos QoS, retain bool, topic string, body []byte) error
 
 	// RemoteAddr returns remote address of the client.
 	RemoteAddr() net.Addr
 
+	// Listener returns the listener which accepted the client.
+	Listener() *Listener
+
 	// Close disconnects the client.
 	Close()
 }
@@ -26,6 +32,7 @@ type Client interface {
 type client struct {
 	srv  *Server
 	conn net.Conn
+	l    *Listener
 
 	wg     sync.WaitGroup
 	quit   chan bool
@@ -35,6 +42,14 @@ type client struct {
 	rd packet.Reader
 	ca ClientAdapter
 	pf PacketFilter
+	dh DeliveryHandler
+
+	// session related.
+	sess    *session
+	clean   bool
+	pl      sync.Mutex
+	ready   bool              // true when the session is ready to publish.
+	pending []*packet.Publish // published before the session is ready.
 
 	// monitorLoop related.
 	md time.Duration
@@ -44,10 +59,11 @@ type client struct {
 
 var _ Client = (*client)(nil)
 
-func newClient(srv *Server, conn net.Conn) *client {
+func newClient(srv *Server, conn net.Conn, l *Listener) *client {
 	return &client{
 		srv:  srv,
 		conn: conn,
+		l:    l,
 		quit: make(chan bool, 1),
 		sq:   make(chan packet.Packet, 1),
 		rd:   bufio.NewReader(conn),
@@ -72,21 +88,27 @@ func (c *client) serve() {
 		c.quited = 1
 		close(c.quit)
 		c.conn.Close()
-		close(c.sq)
+		if c.sess != nil {
+			c.discard(c.srv.unbindSession(c))
+		}
+		c.discardPending()
 		c.srv.clientOnDisconnect(c, err)
 		return
 	}
 	c.srv.clientOnStart(c)
-	if !c.srv.options().DisableMonitor {
+	// zero keep alive turns off the monitor. [MQTT-3.1.2-10]
+	if !c.srv.options().DisableMonitor && c.md > 0 {
 		c.wg.Add(1)
 		go c.monitorLoop()
 	}
 	c.wg.Add(1)
 	go c.sendLoop()
+	c.resend()
+	c.start()
 	err = c.recvLoop()
-	close(c.sq)
 	c.wg.Wait() // wait to terminate sendLoop
 	c.srv.clientOnStop(c)
+	c.discard(c.srv.unbindSession(c))
 	c.srv.clientOnDisconnect(c, err)
 }
 
@@ -107,6 +129,11 @@ func (c *client) establish() error {
 	if pf, ok := c.ca.(PacketFilter); ok {
 		c.pf = pf
 	}
+	if dh, ok := c.ca.(DeliveryHandler); ok {
+		c.dh = dh
+	}
+	c.clean = p.CleanSession
+	c.discard(c.srv.bindSession(c, c.ca.IsSessionPresent()))
 	// send success ConnACK.
 	err = c.send(&packet.ConnACK{
 		SessionPresent: c.ca.IsSessionPresent(),
@@ -183,9 +210,6 @@ func (c *client) sendLoop() {
 		case <-c.quit:
 			return
 		case p := <-c.sq:
-			if p == nil {
-				return
-			}
 			err := c.send(p)
 			if err != nil {
 				c.srv.logSendPacketError(c, p, err)
@@ -277,7 +301,7 @@ func (c *client) processPingReq(p *packet.PingReq) error {
 		return err
 	}
 	if f {
-		c.sq <- &packet.PingResp{}
+		c.enqueue(&packet.PingResp{})
 	}
 	return nil
 }
@@ -308,7 +332,7 @@ func (c *client) processSubscribe(p *packet.Subscribe) error {
 		rp.Results[i] = q.toSubscribeResult()
 	}
 	// send it.
-	c.sq <- rp
+	c.enqueue(rp)
 	return nil
 }
 
@@ -317,44 +341,81 @@ func (c *client) processUnsubscribe(p *packet.Unsubscribe) error {
 	if err != nil {
 		return err
 	}
-	c.sq <- &packet.UnsubACK{
+	c.enqueue(&packet.UnsubACK{
 		PacketID: p.PacketID,
-	}
+	})
 	return nil
 }
 
 func (c *client) processPublish(p *packet.Publish) error {
 	m := toMessage(p)
+	// QoS 2 message is delivered to the adapter only once until released.
+	// [MQTT-4.3.3-2]
+	if m.QoS == ExactlyOnce && !c.sess.receive(p.PacketID) {
+		c.enqueue(&packet.PubRec{
+			PacketID: p.PacketID,
+		})
+		return nil
+	}
 	err := c.ca.OnPublish(m)
 	if err != nil {
+		if m.QoS == ExactlyOnce {
+			c.sess.complete(p.PacketID)
+		}
 		return err
 	}
-	if m.QoS.needPubACK() {
-		c.sq <- &packet.PubACK{
+	switch m.QoS {
+	case AtLeastOnce:
+		c.enqueue(&packet.PubACK{
 			PacketID: p.PacketID,
-		}
+		})
+	case ExactlyOnce:
+		c.enqueue(&packet.PubRec{
+			PacketID: p.PacketID,
+		})
 	}
 	return nil
 }
 
 func (c *client) processPubACK(p *packet.PubACK) error {
-	// FIXME: QoS1 will be supported in future.
-	return ErrNotSuported
+	if sp := c.sess.ack(p.PacketID, packet.QAtLeastOnce); sp != nil {
+		c.onDelivery(sp, nil)
+	}
+	return nil
 }
 
 func (c *client) processPubRec(p *packet.PubRec) error {
-	// FIXME: QoS2 will be supported in future.
-	return ErrNotSuported
+	c.sess.release(p.PacketID)
+	c.enqueue(&packet.PubRel{
+		PacketID: p.PacketID,
+	})
+	return nil
 }
 
 func (c *client) processPubRel(p *packet.PubRel) error {
-	// FIXME: QoS2 will be supported in future.
-	return ErrNotSuported
+	c.sess.complete(p.PacketID)
+	c.enqueue(&packet.PubComp{
+		PacketID: p.PacketID,
+	})
+	return nil
 }
 
 func (c *client) processPubComp(p *packet.PubComp) error {
-	// FIXME: QoS2 will be supported in future.
-	return ErrNotSuported
+	if sp := c.sess.ack(p.PacketID, packet.QExactlyOnce); sp != nil {
+		c.onDelivery(sp, nil)
+	}
+	return nil
+}
+
+// enqueue queues a packet to be sent by sendLoop.  It returns false when the
+// client is terminated.
+func (c *client) enqueue(p packet.Packet) bool {
+	select {
+	case <-c.quit:
+		return false
+	case c.sq <- p:
+		return true
+	}
 }
 
 func (c *client) send(p packet.Packet) error {
@@ -387,26 +448,126 @@ func (c *client) Publish(qos QoS, retain bool, topic string, body []byte) error
 	switch qos {
 	case AtMostOnce:
 		return c.publish0(retain, topic, body)
+	case AtLeastOnce, ExactlyOnce:
+		return c.publishWithAck(qos, retain, topic, body)
 	default:
 		return ErrUnsupportedQoS
 	}
 }
 
 func (c *client) publish0(retain bool, topic string, body []byte) error {
-	p := &packet.Publish{
+	return c.publish(&packet.Publish{
 		QoS:       AtMostOnce.qos(),
 		Retain:    retain,
 		TopicName: topic,
 		Payload:   body,
+	})
+}
+
+func (c *client) publishWithAck(qos QoS, retain bool, topic string, body []byte) error {
+	return c.publish(&packet.Publish{
+		QoS:       qos.qos(),
+		Retain:    retain,
+		TopicName: topic,
+		Payload:   body,
+	})
+}
+
+// publish sends a PUBLISH packet, or holds it until the session is ready.
+func (c *client) publish(p *packet.Publish) error {
+	c.pl.Lock()
+	defer c.pl.Unlock()
+	select {
+	case <-c.quit:
+		return ErrClientClosed
+	default:
+	}
+	if !c.ready {
+		// CONNACK is not sent yet, e.g. called in Adapter#Connect().
+		c.pending = append(c.pending, p)
+		return nil
+	}
+	return c.publishReady(p)
+}
+
+// start makes the session ready to publish, and sends held messages.
+func (c *client) start() {
+	c.pl.Lock()
+	defer c.pl.Unlock()
+	c.ready = true
+	for _, p := range c.pending {
+		if err := c.publishReady(p); err != nil {
+			c.srv.logSendPacketError(c, p, err)
+		}
+	}
+	c.pending = nil
+}
+
+// discardPending notifies held messages which are discarded without
+// sending.
+func (c *client) discardPending() {
+	c.pl.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.pl.Unlock()
+	for _, p := range pending {
+		if p.QoS != packet.QAtMostOnce {
+			c.onDelivery(p, ErrClientClosed)
+		}
+	}
+}
+
+func (c *client) publishReady(p *packet.Publish) error {
+	if p.QoS == packet.QAtMostOnce {
+		if !c.enqueue(p) {
+			return ErrClientClosed
+		}
+		return nil
+	}
+	if err := c.sess.add(p); err != nil {
+		return err
+	}
+	if !c.enqueue(p) {
+		if c.clean {
+			c.sess.remove(p.PacketID)
+			return ErrClientClosed
+		}
+		// it will be resent when the client reconnects.
 	}
-	c.sq <- p
 	return nil
 }
 
+// resend sends in-flight messages of the session again: PUBLISH with DUP
+// flag, or PUBREL. [MQTT-4.4.0-1]
+func (c *client) resend() {
+	for _, o := range c.sess.outbounds() {
+		if !c.enqueue(o.packet()) {
+			return
+		}
+	}
+}
+
+func (c *client) onDelivery(p *packet.Publish, err error) {
+	if c.dh != nil {
+		c.dh.OnDelivery(toMessage(p), err)
+	}
+}
+
+// discard notifies in-flight messages which are discarded with the session.
+func (c *client) discard(list []*outbound) {
+	for _, o := range list {
+		c.onDelivery(o.p, ErrSessionDiscarded)
+	}
+}
+
 func (c *client) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+func (c *client) Listener() *Listener {
+	return c.l
+}
+
 func (c *client) Close() {
 	c.terminate()
 }
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// Listener represents a configuration of a listener of the server.
+type Listener struct {
+	// Name is an optional name of the listener, for adapters to identify it.
+	Name string
+
+	// Addr is an URL to listen on, same form as Server.Addr.
+	Addr string
+
+	// Listener is an established listener to accept connections, for
+	// example a WebSocketListener mounted on your HTTP server.  It is used
+	// when Addr is empty, and closed when the server is closed.
+	Listener net.Listener
+
+	// TLSConfig is used for "tls" and "wss" schemes.  Options.TLSConfig is
+	// used when it is nil.
+	TLSConfig *tls.Config
+}
+
+// listener is a listening listener with its configuration.
+type listener struct {
+	net.Listener
+	cnf *Listener
+}
@@ -59,19 +59,6 @@ func (q QoS) qos() packet.QoS {
 	}
 }
 
-func (q QoS) needPubACK() bool {
-	switch q {
-	case AtMostOnce:
-		return false
-	case AtLeastOnce:
-		return true
-	case ExactlyOnce:
-		return true
-	default:
-		return false
-	}
-}
-
 func toQoS(v packet.QoS) QoS {
 	switch v {
 	case packet.QAtMostOnce:
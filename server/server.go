@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -26,6 +27,9 @@ var (
 
 	// ErrNotServing indicates the server is not under serving.
 	ErrNotServing = errors.New("server is not serving")
+
+	// ErrClientClosed indicates the client is disconnected already.
+	ErrClientClosed = errors.New("client closed")
 )
 
 const (
@@ -41,13 +45,17 @@ type Server struct {
 	Adapter Adapter
 	Options *Options
 
-	st       int32
-	logger   *log.Logger
-	quit     chan bool
-	listener net.Listener
-	wg       sync.WaitGroup // for client#serve()
-	cl       sync.Mutex
-	cs       map[*client]bool
+	// Listeners listen on multiple addresses instead of Addr.
+	Listeners []*Listener
+
+	st        int32
+	logger    *log.Logger
+	quit      chan bool
+	listeners []*listener
+	wg        sync.WaitGroup // for client#serve()
+	cl        sync.Mutex
+	cs        map[*client]bool
+	ss        map[string]*session // sessions by client ID, guarded by cl
 }
 
 func (srv *Server) addr() string {
@@ -64,56 +72,157 @@ func (srv *Server) options() *Options {
 	return srv.Options
 }
 
-// ListenAndServe listens on the TCP network address
+// ListenAndServe listens on the network addresses: Listeners, or Addr when
+// Listeners is empty.  An address is an URL with scheme "tcp", "tls" (or
+// "ssl", "tcps"), "ws" or "wss".  For WebSocket, the path of the URL is used
+// to accept MQTT connections.  A Listener without Addr serves its established
+// net.Listener.
 func (srv *Server) ListenAndServe() error {
-	u, err := url.Parse(srv.addr())
-	if err != nil {
-		return err
+	cnfs := srv.Listeners
+	if len(cnfs) == 0 {
+		cnfs = []*Listener{{Addr: srv.addr()}}
 	}
-	var l net.Listener
-	switch u.Scheme {
-	case "tcp":
-		l, err = net.Listen(u.Scheme, u.Host)
-		if err != nil {
-			return err
+	ls := make([]*listener, 0, len(cnfs))
+	closeAll := func() {
+		for _, l := range ls {
+			l.Close()
 		}
-	case "ssl", "tcps", "tls":
-		l, err = tls.Listen("tcp", u.Host, srv.Options.TLSConfig)
+	}
+	for _, cnf := range cnfs {
+		l, err := srv.listen(cnf)
 		if err != nil {
+			closeAll()
 			return err
 		}
-	default:
-		return ErrUnknownProtocol
+		ls = append(ls, &listener{Listener: l, cnf: cnf})
 	}
-	err = srv.Serve(l)
+	err := srv.serve(ls)
 	if err != nil {
 		if err == ErrAlreadyServerd {
-			l.Close()
+			closeAll()
 		}
 		return err
 	}
 	return nil
 }
 
+// listen listens on the address of the listener configuration.
+func (srv *Server) listen(cnf *Listener) (net.Listener, error) {
+	if cnf.Addr == "" && cnf.Listener != nil {
+		return cnf.Listener, nil
+	}
+	u, err := url.Parse(cnf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		return net.Listen(u.Scheme, u.Host)
+	case "ssl", "tcps", "tls":
+		return tls.Listen("tcp", u.Host, srv.tlsConfig(cnf))
+	case "ws", "wss":
+		return srv.listenWebSocket(u, srv.tlsConfig(cnf))
+	default:
+		return nil, ErrUnknownProtocol
+	}
+}
+
+func (srv *Server) tlsConfig(cnf *Listener) *tls.Config {
+	if cnf.TLSConfig != nil {
+		return cnf.TLSConfig
+	}
+	return srv.options().TLSConfig
+}
+
+// checkTLSConfig validates a TLS configuration for servers, as same as
+// tls.Listen does.
+func checkTLSConfig(tc *tls.Config) error {
+	if tc == nil || len(tc.Certificates) == 0 && tc.GetCertificate == nil && tc.GetConfigForClient == nil {
+		return errors.New("tls: neither Certificates, GetCertificate, nor GetConfigForClient set in Config")
+	}
+	return nil
+}
+
+// listenWebSocket starts a HTTP server which accepts MQTT over WebSocket on
+// the path of u.
+func (srv *Server) listenWebSocket(u *url.URL, tc *tls.Config) (net.Listener, error) {
+	if u.Scheme == "wss" {
+		if err := checkTLSConfig(tc); err != nil {
+			return nil, err
+		}
+	}
+	tl, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tl = tls.NewListener(tl, tc)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	l := NewWebSocketListener(tl.Addr())
+	mux := http.NewServeMux()
+	mux.Handle(path, l)
+	hs := &http.Server{Handler: mux}
+	l.closer = hs.Close
+	go func() {
+		err := hs.Serve(tl)
+		l.closeWithError(err)
+	}()
+	return l, nil
+}
+
 // Serve accepts incoming connections on the Listener.
 func (srv *Server) Serve(l net.Listener) error {
+	a := l.Addr()
+	return srv.serve([]*listener{{
+		Listener: l,
+		cnf:      &Listener{Addr: a.Network() + "://" + a.String()},
+	}})
+}
+
+// serve accepts incoming connections on all listeners, until the server is
+// closed or one of listeners fails.
+func (srv *Server) serve(ls []*listener) error {
 	if !atomic.CompareAndSwapInt32(&srv.st, none, starting) {
 		return ErrAlreadyServerd
 	}
 	srv.logger = srv.options().Logger
 	srv.quit = make(chan bool, 1)
-	srv.listener = l
+	srv.listeners = ls
 	srv.wg = sync.WaitGroup{}
 	srv.cs = make(map[*client]bool)
+	srv.ss = make(map[string]*session)
 
 	atomic.StoreInt32(&srv.st, running)
-	srv.logServerStart()
+	var wg sync.WaitGroup
+	errs := make([]error, len(ls))
+	for i, l := range ls {
+		srv.logServerStart(l)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.acceptLoop(l)
+		}()
+	}
+	wg.Wait()
+	go srv.terminateAllClients()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *Server) acceptLoop(l *listener) error {
 	delay := backoff.Exp{Min: time.Millisecond * 5}
 	for {
-		conn, err := srv.listener.Accept()
+		conn, err := l.Accept()
 		select {
 		case <-srv.quit:
-			go srv.terminateAllClients()
 			return nil
 		default:
 		}
@@ -123,16 +232,14 @@ func (srv *Server) Serve(l net.Listener) error {
 				delay.Wait()
 				continue
 			}
-			if atomic.CompareAndSwapInt32(&srv.st, running, closed) {
-				srv.listener.Close()
-			}
-			go srv.terminateAllClients()
+			// stop other listeners too.
+			srv.shutdown()
 			return err
 		}
 		delay.Reset()
 
 		// start client goroutine.
-		c := newClient(srv, conn)
+		c := newClient(srv, conn, l.cnf)
 		srv.wg.Add(1)
 		go func() {
 			c.serve()
@@ -144,16 +251,25 @@ func (srv *Server) Serve(l net.Listener) error {
 // Close terminates the server by shutting down all the client connections and
 // closing.
 func (srv *Server) Close() error {
-	if !atomic.CompareAndSwapInt32(&srv.st, running, closed) {
+	if !srv.shutdown() {
 		return ErrNotServing
 	}
-	// terminate server.
-	close(srv.quit)
-	srv.listener.Close()
 	srv.wg.Wait()
 	return nil
 }
 
+// shutdown stops all listeners.
+func (srv *Server) shutdown() bool {
+	if !atomic.CompareAndSwapInt32(&srv.st, running, closed) {
+		return false
+	}
+	close(srv.quit)
+	for _, l := range srv.listeners {
+		l.Close()
+	}
+	return true
+}
+
 func (srv *Server) terminateAllClients() {
 	srv.cl.Lock()
 	for c := range srv.cs {
@@ -169,8 +285,8 @@ func (srv *Server) logf(fmt string, a ...interface{}) {
 	srv.logger.Printf(fmt, a...)
 }
 
-func (srv *Server) logServerStart() {
-	srv.logf("MQTT server listen on: %s\n", srv.listener.Addr().String())
+func (srv *Server) logServerStart(l *listener) {
+	srv.logf("MQTT server listen on: %s\n", l.Addr().String())
 }
 
 func (srv *Server) logTemporaryError(err net.Error, d *backoff.Exp, c *client) {
@@ -221,6 +337,42 @@ func (srv *Server) clientOnStop(c *client) {
 	delete(srv.cs, c)
 }
 
+// bindSession binds a session to the client.  The stored session is
+// reused when the adapter restores the previous session.  It returns
+// in-flight messages which are discarded with the previous session.
+func (srv *Server) bindSession(c *client, present bool) []*outbound {
+	srv.cl.Lock()
+	defer srv.cl.Unlock()
+	id := c.id()
+	s, ok := srv.ss[id]
+	if ok && present {
+		c.sess = s
+		return nil
+	}
+	c.sess = newSession()
+	srv.ss[id] = c.sess
+	if !ok {
+		return nil
+	}
+	return s.clear()
+}
+
+// unbindSession discards the session of the client if it uses CleanSession.
+// It returns in-flight messages which are discarded.
+func (srv *Server) unbindSession(c *client) []*outbound {
+	if !c.clean {
+		return nil
+	}
+	srv.cl.Lock()
+	defer srv.cl.Unlock()
+	if srv.ss[c.id()] != c.sess {
+		// the session is taken over by another connection.
+		return nil
+	}
+	delete(srv.ss, c.id())
+	return c.sess.clear()
+}
+
 func (srv *Server) clientOnDisconnect(c *client, err error) {
 	srv.adapter().Disconnect(srv, c.ca, err)
 }
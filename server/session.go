@@ -0,0 +1,174 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/koron/go-mqtt/packet"
+)
+
+var (
+	// ErrPacketIDExhausted indicates all packet IDs are used by in-flight
+	// messages.
+	ErrPacketIDExhausted = errors.New("packet ID exhausted")
+
+	// ErrSessionDiscarded indicates in-flight messages are discarded with
+	// the session, by CleanSession.
+	ErrSessionDiscarded = errors.New("session discarded")
+)
+
+// DeliveryHandler receives results of QoS 1 and 2 messages which the server
+// published to the client.  ClientAdapter can implement DeliveryHandler.
+type DeliveryHandler interface {
+	// OnDelivery is called when the client acknowledged a message, or the
+	// message is discarded with its session.
+	OnDelivery(m *Message, err error)
+}
+
+// outbound is an in-flight message which is sent to the client.
+type outbound struct {
+	p   *packet.Publish
+	seq uint64
+
+	// rel is true after PUBREC is received for QoS 2 message, then PUBREL is
+	// sent instead of PUBLISH.
+	rel bool
+}
+
+// packet returns a packet to be sent again.
+func (o *outbound) packet() packet.Packet {
+	if o.rel {
+		return &packet.PubRel{PacketID: o.p.PacketID}
+	}
+	dp := *o.p
+	dp.Dup = true
+	return &dp
+}
+
+// session holds state of a client, which lives over connections while the
+// client doesn't use CleanSession.
+type session struct {
+	mu   sync.Mutex
+	id   packet.ID
+	seq  uint64
+	outs map[packet.ID]*outbound
+
+	// ins holds packet IDs of QoS 2 messages which are received but not
+	// released yet.
+	ins map[packet.ID]struct{}
+}
+
+func newSession() *session {
+	return &session{
+		outs: make(map[packet.ID]*outbound),
+		ins:  make(map[packet.ID]struct{}),
+	}
+}
+
+// emitID returns a new packet ID which is not used by in-flight messages.
+func (s *session) emitID() (packet.ID, bool) {
+	for range 0xffff {
+		s.id++
+		if s.id == 0 {
+			s.id = 1
+		}
+		if _, ok := s.outs[s.id]; !ok {
+			return s.id, true
+		}
+	}
+	return 0, false
+}
+
+// add assigns a packet ID to p, and holds it until acknowledged.
+func (s *session) add(p *packet.Publish) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.emitID()
+	if !ok {
+		return ErrPacketIDExhausted
+	}
+	p.PacketID = id
+	s.seq++
+	s.outs[id] = &outbound{p: p, seq: s.seq}
+	return nil
+}
+
+// remove removes an in-flight message, and returns it.
+func (s *session) remove(id packet.ID) *packet.Publish {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.outs[id]
+	if !ok {
+		return nil
+	}
+	delete(s.outs, id)
+	return o.p
+}
+
+// ack removes an in-flight message by the last acknowledgement: PUBACK for
+// QoS 1 or PUBCOMP for QoS 2.  It returns nil when the acknowledgement
+// doesn't match with the message.
+func (s *session) ack(id packet.ID, qos packet.QoS) *packet.Publish {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.outs[id]
+	if !ok || o.p.QoS != qos || (qos == packet.QExactlyOnce && !o.rel) {
+		return nil
+	}
+	delete(s.outs, id)
+	return o.p
+}
+
+// release marks a QoS 2 message as received by the client.
+func (s *session) release(id packet.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.outs[id]; ok && o.p.QoS == packet.QExactlyOnce {
+		o.rel = true
+	}
+}
+
+// receive records a packet ID of an incoming QoS 2 message.  It returns false
+// when the message is received already.
+func (s *session) receive(id packet.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ins[id]; ok {
+		return false
+	}
+	s.ins[id] = struct{}{}
+	return true
+}
+
+// complete forgets a packet ID of an incoming QoS 2 message.
+func (s *session) complete(id packet.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ins, id)
+}
+
+// outbounds returns in-flight messages in order of sent.
+func (s *session) outbounds() []*outbound {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedOutbounds()
+}
+
+// clear removes all in-flight messages, and returns them.
+func (s *session) clear() []*outbound {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.sortedOutbounds()
+	clear(s.outs)
+	clear(s.ins)
+	return list
+}
+
+func (s *session) sortedOutbounds() []*outbound {
+	return slices.SortedFunc(maps.Values(s.outs), func(a, b *outbound) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+}
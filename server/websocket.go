@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsProtocol is the WebSocket subprotocol for MQTT.
+const wsProtocol = "mqtt"
+
+var errOriginRejected = errors.New("websocket origin rejected")
+
+// WebSocketListener is a net.Listener which accepts MQTT connections over
+// WebSocket.  It is also a http.Handler, so it can be mounted on any HTTP
+// server, then pass it to Server#Serve().
+type WebSocketListener struct {
+	// CheckOrigin checks Origin header of a request to prevent cross-site
+	// WebSocket hijacking.  It returns false to reject the request.  When it
+	// is nil, requests from other origins than the Host header are rejected,
+	// and requests without Origin header are accepted.  Set it before
+	// serving.
+	CheckOrigin func(r *http.Request) bool
+
+	addr   net.Addr
+	ch     chan net.Conn
+	quit   chan struct{}
+	once   sync.Once
+	err    error
+	closer func() error
+	ws     websocket.Server
+}
+
+var (
+	_ net.Listener = (*WebSocketListener)(nil)
+	_ http.Handler = (*WebSocketListener)(nil)
+)
+
+// NewWebSocketListener creates a new WebSocketListener.  addr is returned by
+// Addr(), it can be nil.
+func NewWebSocketListener(addr net.Addr) *WebSocketListener {
+	l := &WebSocketListener{
+		addr: addr,
+		ch:   make(chan net.Conn),
+		quit: make(chan struct{}),
+		err:  net.ErrClosed,
+	}
+	l.ws = websocket.Server{
+		Handshake: l.handshake,
+		Handler:   l.serveWebSocket,
+	}
+	return l
+}
+
+// handshake checks the origin, and selects "mqtt" subprotocol if the client
+// offers it.
+func (l *WebSocketListener) handshake(cnf *websocket.Config, r *http.Request) error {
+	check := l.CheckOrigin
+	if check == nil {
+		check = sameOrigin
+	}
+	if !check(r) {
+		return errOriginRejected
+	}
+	if slices.Contains(cnf.Protocol, wsProtocol) {
+		cnf.Protocol = []string{wsProtocol}
+	} else {
+		cnf.Protocol = nil
+	}
+	return nil
+}
+
+// sameOrigin checks the host of Origin header is same with Host header.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// ServeHTTP upgrades a HTTP request to WebSocket, and passes it to Accept().
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.ws.ServeHTTP(w, r)
+}
+
+func (l *WebSocketListener) serveWebSocket(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	c := &wsConn{
+		Conn: ws,
+		done: make(chan struct{}),
+	}
+	if ap, err := netip.ParseAddrPort(ws.Request().RemoteAddr); err == nil {
+		c.raddr = net.TCPAddrFromAddrPort(ap)
+	}
+	select {
+	case l.ch <- c:
+	case <-l.quit:
+		ws.Close()
+		return
+	}
+	// the connection is closed when returned.
+	<-c.done
+}
+
+// Accept waits for and returns the next connection.
+func (l *WebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ch:
+		return c, nil
+	case <-l.quit:
+		return nil, l.err
+	}
+}
+
+// Close closes the listener.
+func (l *WebSocketListener) Close() error {
+	return l.closeWithError(net.ErrClosed)
+}
+
+func (l *WebSocketListener) closeWithError(err error) error {
+	var cerr error
+	l.once.Do(func() {
+		l.err = err
+		close(l.quit)
+		if l.closer != nil {
+			cerr = l.closer()
+		}
+	})
+	return cerr
+}
+
+// Addr returns the listener's network address.
+func (l *WebSocketListener) Addr() net.Addr {
+	if l.addr == nil {
+		return wsAddr{}
+	}
+	return l.addr
+}
+
+type wsAddr struct{}
+
+func (wsAddr) Network() string { return "websocket" }
+func (wsAddr) String() string  { return "websocket" }
+
+// wsConn is a MQTT connection over WebSocket.
+type wsConn struct {
+	*websocket.Conn
+	raddr net.Addr
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	if c.raddr != nil {
+		return c.raddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return err
+}